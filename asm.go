@@ -0,0 +1,91 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+/*
+	Functions are generated as lists of assembly lines, rather than text,
+	so they can be optimised before they're written out. The runtime is
+	written by hand so is still text.
+*/
+
+type AsmLine interface {
+	IsAsmLine()
+}
+
+type AsmInstr struct {
+	op       string
+	operands []string
+}
+
+type AsmLabel struct {
+	name string
+}
+
+type AsmComment struct {
+	text string
+}
+
+// inline assembly and data, written out as it is
+type AsmRaw struct {
+	text string
+}
+
+func (AsmInstr) IsAsmLine()   {}
+func (AsmLabel) IsAsmLine()   {}
+func (AsmComment) IsAsmLine() {}
+func (AsmRaw) IsAsmLine()     {}
+
+func asmInstr(op string, operands ...string) AsmInstr {
+	return AsmInstr{op: op, operands: operands}
+}
+
+func asmLinesString(lines []AsmLine) string {
+	output := ""
+	for _, rawLine := range lines {
+		switch line := rawLine.(type) {
+		case AsmInstr:
+			output += "\t" + line.op
+			if len(line.operands) > 0 {
+				output += " " + strings.Join(line.operands, ", ")
+			}
+			output += "\n"
+		case AsmLabel:
+			output += line.name + ":\n"
+		case AsmComment:
+			output += "\t; " + line.text + "\n"
+		case AsmRaw:
+			output += "\t" + line.text + "\n"
+		default:
+			panic(fmt.Errorf("asm error: unknown line: %T", rawLine))
+		}
+	}
+	return output
+}
+
+// every 64 bit general purpose register
+var generalRegisters = []string{
+	"rax", "rbx", "rcx", "rdx", "rsi", "rdi", "rbp", "rsp",
+	"r8", "r9", "r10", "r11", "r12", "r13", "r14", "r15",
+}
+
+func isMemoryOperand(operand string) bool {
+	return strings.Contains(operand, "[")
+}
+
+// the registers an operand is or uses to address memory
+func operandRegisters(operand string) []string {
+	registers := []string{}
+	words := strings.FieldsFunc(operand, func(r rune) bool { return !unicode.IsLetter(r) && !unicode.IsDigit(r) })
+	for _, w := range words {
+		for _, reg := range generalRegisters {
+			if w == reg {
+				registers = append(registers, reg)
+			}
+		}
+	}
+	return registers
+}
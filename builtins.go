@@ -0,0 +1,53 @@
+package main
+
+/*
+	Builtins are called like functions but are lowered to their own IR
+	instructions or calls into the runtime. Functions a file can see with the same name take
+	priority over them so adding a builtin never breaks existing programs.
+*/
+
+type Builtin struct {
+	name      string
+	arguments int
+	returns   int
+}
+
+var builtins = []Builtin{
+	{"vaCount", 1, 1},
+	{"vaArg", 2, 1},
+	{"alloc", 1, 1},
+	{"free", 1, 0},
+	{"errno", 0, 1},
+	{"argc", 0, 1},
+	{"argv", 0, 1},
+	{"envp", 0, 1},
+}
+
+func findBuiltin(name string) (Builtin, bool) {
+	for _, b := range builtins {
+		if b.name == name {
+			return b, true
+		}
+	}
+	return Builtin{}, false
+}
+
+// the kernel starts a process with argc at the top of the stack followed
+// by the argv pointers then a 0, and then the envp pointers then a 0.
+// they're saved before anything else can move rsp
+func (g *Generator) GenProcessArgsCapture() []AsmLine {
+	g.reserveGlobal("molten_argc")
+	g.reserveGlobal("molten_argv")
+	g.reserveGlobal("molten_envp")
+
+	output := g.comment("---process_args---")
+	output = append(output, asmInstr("mov", "rax", "[rsp]"))
+	output = append(output, asmInstr("mov", "[rel molten_argc]", "rax"))
+	output = append(output, asmInstr("lea", "rax", "[rsp + 8]"))
+	output = append(output, asmInstr("mov", "[rel molten_argv]", "rax"))
+	output = append(output, asmInstr("mov", "rcx", "[rsp]"))
+	output = append(output, asmInstr("lea", "rax", "[rsp + rcx*8 + 16]"))
+	output = append(output, asmInstr("mov", "[rel molten_envp]", "rax"))
+
+	return output
+}
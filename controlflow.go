@@ -0,0 +1,138 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+)
+
+/*
+	Control flow falls through a statement if the code after it can run
+	next. Returning, breaking, continuing and exiting with a syscall never
+	fall through. An if only falls through when one of its branches does,
+	so an if without an else always can. `while` with a constant true
+	condition is an infinite loop so only falls through when broken out of.
+*/
+
+// whether control can reach the end of a list of statements
+func fallsThrough(stmts []NodeStmt) bool {
+	for _, stmt := range stmts {
+		if !stmtFallsThrough(stmt) {
+			return false
+		}
+	}
+	return true
+}
+
+func stmtFallsThrough(rawStmt NodeStmt) bool {
+	switch stmt := rawStmt.(type) {
+	case NodeStmtReturn, NodeStmtBreak, NodeStmtContinue:
+		return false
+
+	case NodeStmtSyscall:
+		info, known := knownSyscall(stmt.arguments[0])
+		return !known || (info.name != "exit" && info.name != "exit_group")
+
+	case NodeScope:
+		return fallsThrough(stmt.stmts)
+
+	case NodeStmtIf:
+		return ifFallsThrough(stmt)
+
+	case NodeStmtWhile:
+		value, constant := constantValue(stmt.expr)
+		return !constant || value == 0 || breaksOut(stmt.scope.stmts)
+
+	default:
+		return true
+	}
+}
+
+func ifFallsThrough(stmt NodeStmtIf) bool {
+	if !stmt.elseBranch.HasValue() || fallsThrough(stmt.scope.stmts) {
+		return true
+	}
+
+	switch _else := stmt.elseBranch.MustGetValue().(type) {
+	case NodeElseScope:
+		return fallsThrough(_else.scope.stmts)
+	case NodeElseElif:
+		return ifFallsThrough(_else.ifStmt)
+	default:
+		panic(fmt.Errorf("control flow error: unknown else branch: %T", _else))
+	}
+}
+
+// whether any of the statements break out of the loop they're in.
+// breaks in nested loops leave the nested loop instead
+func breaksOut(stmts []NodeStmt) bool {
+	for _, rawStmt := range stmts {
+		switch stmt := rawStmt.(type) {
+		case NodeStmtBreak:
+			return true
+		case NodeScope:
+			if breaksOut(stmt.stmts) {
+				return true
+			}
+		case NodeStmtIf:
+			if ifBreaksOut(stmt) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func ifBreaksOut(stmt NodeStmtIf) bool {
+	if breaksOut(stmt.scope.stmts) {
+		return true
+	}
+	if !stmt.elseBranch.HasValue() {
+		return false
+	}
+
+	switch _else := stmt.elseBranch.MustGetValue().(type) {
+	case NodeElseScope:
+		return breaksOut(_else.scope.stmts)
+	case NodeElseElif:
+		return ifBreaksOut(_else.ifStmt)
+	default:
+		panic(fmt.Errorf("control flow error: unknown else branch: %T", _else))
+	}
+}
+
+// the value of an expression made only of int literals, worked out the
+// same way as the program would. dividing by zero isn't constant as it
+// crashes the program instead
+func constantValue(rawExpr NodeExpr) (IRConst, bool) {
+	binary := func(op IRBinaryOp, left NodeExpr, right NodeExpr) (IRConst, bool) {
+		l, ok := constantValue(left)
+		if !ok {
+			return 0, false
+		}
+		r, ok := constantValue(right)
+		if !ok || (r == 0 && (op == irDivide || op == irModulo)) {
+			return 0, false
+		}
+		return evalBinaryOp(op, l, r), true
+	}
+
+	switch expr := rawExpr.(type) {
+	case NodeTermIntLiteral:
+		value, err := strconv.ParseUint(expr.intLiteral.value.MustGetValue(), 10, 64)
+		return IRConst(value), err == nil
+	case NodeTermRoundBracketExpr:
+		return constantValue(expr.expr)
+	case NodeBinExprAdd:
+		return binary(irAdd, expr.left, expr.right)
+	case NodeBinExprSubtract:
+		return binary(irSubtract, expr.left, expr.right)
+	case NodeBinExprMultiply:
+		return binary(irMultiply, expr.left, expr.right)
+	case NodeBinExprDivide:
+		return binary(irDivide, expr.left, expr.right)
+	case NodeBinExprModulo:
+		return binary(irModulo, expr.left, expr.right)
+	default:
+		return 0, false
+	}
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"slices"
+)
+
+/*
+	Dead code elimination drops what can never run. Blocks are kept if
+	they can be reached from the start of their function, which gets rid
+	of code after a return, break or continue and the branches constant
+	folding decided can't be taken. Functions are kept if they can be
+	reached from the top level code through calls or by their address
+	being taken, after the blocks are gone so a call that can't run
+	doesn't keep a function.
+
+	Nothing is exported from a program so the top level code is the only
+	place to start from.
+*/
+
+func removeDeadCode(program *IRProgram) {
+	for _, function := range append(program.functions, program.start) {
+		removeUnreachableBlocks(function)
+		mergeBlocks(function)
+	}
+
+	reachable := reachableFunctions(program)
+	program.functions = slices.DeleteFunc(program.functions, func(f *IRFunction) bool {
+		return !reachable[f.function]
+	})
+}
+
+// blocks that can't be reached from the first one, which is where the
+// function starts. terminators can have changed since the blocks were
+// linked so they're linked before and after
+func removeUnreachableBlocks(function *IRFunction) {
+	function.linkBlocks()
+
+	reachable := map[*IRBlock]bool{function.blocks[0]: true}
+	toVisit := []*IRBlock{function.blocks[0]}
+
+	for len(toVisit) > 0 {
+		b := toVisit[len(toVisit)-1]
+		toVisit = toVisit[:len(toVisit)-1]
+
+		for _, s := range b.successors {
+			if !reachable[s] {
+				reachable[s] = true
+				toVisit = append(toVisit, s)
+			}
+		}
+	}
+
+	function.blocks = slices.DeleteFunc(function.blocks, func(b *IRBlock) bool { return !reachable[b] })
+	function.linkBlocks()
+}
+
+// joins blocks onto the block before them when it's the only one that
+// goes to them and it can only go there, which inlining and folding
+// branches leave a lot of
+func mergeBlocks(function *IRFunction) {
+	merged := map[*IRBlock]bool{}
+	for _, b := range function.blocks {
+		if merged[b] {
+			continue
+		}
+		for {
+			jump, ok := b.terminator.(IRJump)
+			if !ok || jump.target == b || jump.target == function.blocks[0] || len(jump.target.predecessors) != 1 {
+				break
+			}
+			b.instrs = append(b.instrs, jump.target.instrs...)
+			b.terminator = jump.target.terminator
+			merged[jump.target] = true
+		}
+	}
+
+	function.blocks = slices.DeleteFunc(function.blocks, func(b *IRBlock) bool { return merged[b] })
+	function.linkBlocks()
+}
+
+// every function that can be reached from the top level code through calls and function values
+func reachableFunctions(program *IRProgram) map[*Function]bool {
+	irFunctions := map[*Function]*IRFunction{}
+	for _, f := range program.functions {
+		irFunctions[f.function] = f
+	}
+
+	reachable := map[*Function]bool{}
+	toVisit := []*IRFunction{program.start}
+
+	for len(toVisit) > 0 {
+		f := toVisit[len(toVisit)-1]
+		toVisit = toVisit[:len(toVisit)-1]
+
+		for _, b := range f.blocks {
+			for _, rawInstr := range b.instrs {
+				var referenced *Function
+				switch instr := rawInstr.(type) {
+				case IRCall:
+					referenced = instr.function
+				case IRFunctionAddress:
+					referenced = instr.function
+				default:
+					continue
+				}
+
+				if !reachable[referenced] {
+					reachable[referenced] = true
+					toVisit = append(toVisit, irFunctions[referenced])
+				}
+			}
+		}
+	}
+	return reachable
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestRemoveDeadCode(t *testing.T) {
+	source := `
+func 0 unused() { syscall(60, 1); }
+func 0 called() { syscall(60, 2); }
+func 0 addressed() { syscall(60, 3); }
+func 0 onlyDead() { syscall(60, 4); }
+func 0 calledByUnused() { syscall(60, 5); }
+func 0 chain() { unusedToo(); }
+func 0 unusedToo() { calledByUnused(); }
+func 1 early(x) {
+	return x;
+	syscall(60, 6);
+}
+
+if (2 - 2) {
+	onlyDead();
+}
+called();
+var f = addressed;
+f();
+syscall(60, early(1));
+`
+	ir := lowerSource(t, source)
+	if err := foldConstants(&ir); err != nil {
+		t.Fatal(err)
+	}
+	removeDeadCode(&ir)
+
+	got := []string{}
+	for _, f := range ir.functions {
+		got = append(got, f.function.name)
+	}
+	slices.Sort(got)
+	want := []string{"addressed", "called", "early"}
+	if !slices.Equal(got, want) {
+		t.Errorf("got functions %v, want %v", got, want)
+	}
+
+	// the code after the return is gone
+	early := findIRFunction(t, ir, "early")
+	if syscalls := findInstrs[IRSyscall](early); len(syscalls) != 0 {
+		t.Errorf("got %d syscalls after the return", len(syscalls))
+	}
+	for _, f := range append(ir.functions, ir.start) {
+		for _, b := range f.blocks[1:] {
+			if len(b.predecessors) == 0 {
+				t.Errorf("block %s in %s can't be reached", b.label, f.label)
+			}
+		}
+	}
+}
+
+func TestMergeBlocks(t *testing.T) {
+	// the branch folds to a jump so the blocks it joined become one
+	ir := lowerSource(t, "var x = 1;\nif (x) { x = 2; }\nsyscall(60, x);")
+	if err := foldConstants(&ir); err != nil {
+		t.Fatal(err)
+	}
+	removeDeadCode(&ir)
+
+	if len(ir.start.blocks) != 1 {
+		t.Errorf("got %d blocks, want 1", len(ir.start.blocks))
+	}
+}
@@ -0,0 +1,274 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+)
+
+/*
+	Diagnostics are shown with the lines of source they point at, E.G.
+
+	error[E0302]: variable identifier already used: a
+	 --> main.mltn:2:5
+	  |
+	1 | var a;
+	  |     - previous declaration here
+	2 | var a;
+	  |     ^
+	  = note: variables can't shadow a variable from an enclosing scope
+
+	or as a JSON array for editors and other tools.
+*/
+
+type DiagnosticRenderer struct {
+	// the text of each file by name
+	sources map[string]string
+
+	colour bool
+	json   bool
+}
+
+func NewDiagnosticRenderer(sources map[string]string) DiagnosticRenderer {
+	return DiagnosticRenderer{sources: sources}
+}
+
+// colour is used when writing to a terminal unless NO_COLOR is set
+func isColourTerminal(file *os.File) bool {
+	if os.Getenv("NO_COLOR") != "" || os.Getenv("TERM") == "dumb" {
+		return false
+	}
+	info, err := file.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+const (
+	ansiReset  = "\x1b[0m"
+	ansiBold   = "\x1b[1m"
+	ansiRed    = "\x1b[1;31m"
+	ansiYellow = "\x1b[1;33m"
+	ansiBlue   = "\x1b[1;34m"
+)
+
+// the colour errors and warnings are shown in
+func severityStyle(severity Severity) string {
+	if severity == severityWarning {
+		return ansiYellow
+	}
+	return ansiRed
+}
+
+func (r DiagnosticRenderer) style(style string, text string) string {
+	if !r.colour {
+		return text
+	}
+	return style + text + ansiReset
+}
+
+func (r DiagnosticRenderer) Render(err error) string {
+	errs := ErrorList{}
+	errs.Add(err)
+
+	if r.json {
+		return r.renderJSON(errs)
+	}
+
+	rendered := []string{}
+	for _, e := range errs {
+		diagnostic, ok := e.(Diagnostic)
+		if !ok {
+			rendered = append(rendered, r.style(ansiRed, "error")+r.style(ansiBold, ": "+e.Error())+"\n")
+			continue
+		}
+		rendered = append(rendered, r.renderText(diagnostic))
+	}
+	return strings.TrimSuffix(strings.Join(rendered, "\n"), "\n")
+}
+
+// a span shown under a line of source
+type marker struct {
+	span    Span
+	primary bool
+	message string
+
+	// the colour of the primary span
+	style string
+}
+
+func (r DiagnosticRenderer) renderText(d Diagnostic) string {
+	output := r.style(severityStyle(d.severity), d.severity.String()+"["+d.code+"]") + r.style(ansiBold, ": "+d.message) + "\n"
+
+	markers := []marker{{span: d.span, primary: true, style: severityStyle(d.severity)}}
+	for _, l := range d.labels {
+		markers = append(markers, marker{span: l.span, message: l.message})
+	}
+
+	// the primary span's file comes first then any others
+	files := []string{d.span.start.File}
+	for _, m := range markers {
+		if !slices.Contains(files, m.span.start.File) {
+			files = append(files, m.span.start.File)
+		}
+	}
+
+	gutterWidth := 1
+	for _, m := range markers {
+		gutterWidth = max(gutterWidth, len(fmt.Sprint(m.span.start.Line)))
+	}
+	gutter := strings.Repeat(" ", gutterWidth)
+
+	for i, file := range files {
+		fileMarkers := []marker{}
+		for _, m := range markers {
+			if m.span.start.File == file {
+				fileMarkers = append(fileMarkers, m)
+			}
+		}
+
+		location := fileMarkers[0].span.start
+		arrow := "-->"
+		if i > 0 {
+			arrow = ":::"
+		}
+		output += gutter + r.style(ansiBlue, arrow) + fmt.Sprintf(" %s:%d:%d\n", location.File, location.Line, location.Col)
+
+		source, ok := r.sources[file]
+		if !ok {
+			continue
+		}
+		lines := strings.Split(source, "\n")
+
+		lineNumbers := []int{}
+		for _, m := range fileMarkers {
+			if !slices.Contains(lineNumbers, m.span.start.Line) {
+				lineNumbers = append(lineNumbers, m.span.start.Line)
+			}
+		}
+		slices.Sort(lineNumbers)
+
+		output += gutter + r.style(ansiBlue, " |") + "\n"
+		for j, lineNumber := range lineNumbers {
+			if lineNumber < 1 || lineNumber > len(lines) {
+				continue
+			}
+			if j > 0 && lineNumber > lineNumbers[j-1]+1 {
+				output += r.style(ansiBlue, "...") + "\n"
+			}
+
+			line := []rune(strings.TrimRight(lines[lineNumber-1], "\r"))
+			output += r.style(ansiBlue, fmt.Sprintf("%*d |", gutterWidth, lineNumber)) + " " + string(line) + "\n"
+
+			// markers on the same line are shown left to right
+			lineMarkers := []marker{}
+			for _, m := range fileMarkers {
+				if m.span.start.Line == lineNumber {
+					lineMarkers = append(lineMarkers, m)
+				}
+			}
+			slices.SortStableFunc(lineMarkers, func(a marker, b marker) int {
+				return a.span.start.Col - b.span.start.Col
+			})
+			for _, m := range lineMarkers {
+				output += gutter + r.style(ansiBlue, " |") + " " + r.renderMarker(line, m) + "\n"
+			}
+		}
+	}
+
+	for _, note := range d.notes {
+		output += gutter + r.style(ansiBlue, " =") + r.style(ansiBold, " note") + ": " + note + "\n"
+	}
+	for _, help := range d.help {
+		output += gutter + r.style(ansiBlue, " =") + r.style(ansiBold, " help") + ": " + help + "\n"
+	}
+
+	return output
+}
+
+// underlines the span of a marker on its line of source. tabs before the span
+// are kept so the underline lines up however wide the tabs are shown
+func (r DiagnosticRenderer) renderMarker(line []rune, m marker) string {
+	start := min(max(m.span.start.Col-1, 0), len(line))
+	length := max(min(m.span.length, len(line)-start), 1)
+
+	indent := ""
+	for _, c := range line[:start] {
+		if c == '\t' {
+			indent += "\t"
+		} else {
+			indent += " "
+		}
+	}
+
+	if m.primary {
+		return indent + r.style(m.style, strings.Repeat("^", length))
+	}
+	underline := strings.Repeat("-", length)
+	if m.message != "" {
+		underline += " " + m.message
+	}
+	return indent + r.style(ansiBlue, underline)
+}
+
+type jsonDiagnostic struct {
+	Severity string      `json:"severity"`
+	Code     string      `json:"code,omitempty"`
+	Message  string      `json:"message"`
+	Span     *jsonSpan   `json:"span,omitempty"`
+	Labels   []jsonLabel `json:"labels,omitempty"`
+	Notes    []string    `json:"notes,omitempty"`
+	Help     []string    `json:"help,omitempty"`
+}
+
+type jsonSpan struct {
+	File   string `json:"file"`
+	Line   int    `json:"line"`
+	Column int    `json:"column"`
+	Length int    `json:"length"`
+}
+
+type jsonLabel struct {
+	Span    jsonSpan `json:"span"`
+	Message string   `json:"message"`
+}
+
+func toJSONSpan(span Span) jsonSpan {
+	return jsonSpan{
+		File:   span.start.File,
+		Line:   span.start.Line,
+		Column: span.start.Col,
+		Length: span.length,
+	}
+}
+
+func (r DiagnosticRenderer) renderJSON(errs ErrorList) string {
+	diagnostics := []jsonDiagnostic{}
+	for _, e := range errs {
+		d, ok := e.(Diagnostic)
+		if !ok {
+			diagnostics = append(diagnostics, jsonDiagnostic{Severity: "error", Message: e.Error()})
+			continue
+		}
+
+		span := toJSONSpan(d.span)
+		diagnostic := jsonDiagnostic{
+			Severity: d.severity.String(),
+			Code:     d.code,
+			Message:  d.message,
+			Span:     &span,
+			Notes:    d.notes,
+			Help:     d.help,
+		}
+		for _, l := range d.labels {
+			diagnostic.Labels = append(diagnostic.Labels, jsonLabel{Span: toJSONSpan(l.span), Message: l.message})
+		}
+		diagnostics = append(diagnostics, diagnostic)
+	}
+
+	output, err := json.Marshal(diagnostics)
+	if err != nil {
+		panic(fmt.Errorf("diagnostic error: can't encode diagnostics: %v", err))
+	}
+	return string(output)
+}
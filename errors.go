@@ -0,0 +1,253 @@
+package main
+
+import (
+	"cmp"
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// every kind of error has a code that stays the same between
+// versions of the compiler so tools and people can look it up
+const (
+	// reading the source
+	codeInvalidToken    = "E0001"
+	codeUnclosedString  = "E0002"
+	codeUnclosedComment = "E0003"
+	codeUnclosedAsm     = "E0004"
+	codeInvalidUTF8     = "E0005"
+
+	// parsing
+	codeExpectedToken  = "E0100"
+	codeExpectedExpr   = "E0101"
+	codeExpectedStmt   = "E0102"
+	codeExpectedScope  = "E0103"
+	codeExpectedCall   = "E0104"
+	codeTooManyArgs    = "E0105"
+	codeExpectedElse   = "E0106"
+	codeBadReturnCount = "E0107"
+	codeExpectedAssign = "E0108"
+
+	// imports
+	codeImportCycle      = "E0200"
+	codeUnreadableImport = "E0201"
+
+	// names
+	codeUndefinedVariable   = "E0300"
+	codeUndefinedFunction   = "E0301"
+	codeDuplicateVariable   = "E0302"
+	codeDuplicateFunction   = "E0303"
+	codeAmbiguousCall       = "E0304"
+	codeOverloadedValue     = "E0305"
+	codeVariadicValue       = "E0306"
+	codeUndefinedAsmOperand = "E0307"
+
+	// calls and returns
+	codeArgumentCount   = "E0400"
+	codeNotAValue       = "E0401"
+	codeReturnCount     = "E0402"
+	codeBuiltinArgs     = "E0403"
+	codeVariadicBuiltin = "E0404"
+	codeSyscallArgs     = "E0405"
+	codeTopLevelReturn  = "E0406"
+	codeMissingReturn   = "E0407"
+
+	// statements in the wrong place
+	codeOutsideLoop    = "E0500"
+	codeNestedFunction = "E0501"
+	codeNestedImport   = "E0502"
+	codeBadClobber     = "E0503"
+	codeBadMain        = "E0504"
+
+	// pointers
+	codeEscapingPointer = "E0600"
+
+	// constants
+	codeIntTooBig    = "E0700"
+	codeDivideByZero = "E0701"
+
+	// inlining
+	codeRecursiveInline = "E0800"
+	codeVariadicInline  = "E0801"
+	codeAsmInline       = "E0802"
+
+	// warnings
+	codeUnusedVariable    = "W0001"
+	codeUnusedFunction    = "W0002"
+	codeUnreachableCode   = "W0003"
+	codeConstantCondition = "W0004"
+	codeUninitialised     = "W0005"
+)
+
+type Severity int
+
+const (
+	severityError Severity = iota
+	severityWarning
+)
+
+func (s Severity) String() string {
+	switch s {
+	case severityError:
+		return "error"
+	case severityWarning:
+		return "warning"
+	default:
+		panic(fmt.Errorf("diagnostic error: unknown severity: %d", int(s)))
+	}
+}
+
+// a range of columns on one line of a source file
+type Span struct {
+	start  LineInfo
+	length int
+}
+
+// a secondary span pointed at by a diagnostic, E.G. an earlier declaration
+type Label struct {
+	span    Span
+	message string
+}
+
+// an error or warning at a position in a source file
+type Diagnostic struct {
+	severity Severity
+	code     string
+	message  string
+	span     Span
+
+	labels []Label
+	notes  []string
+	help   []string
+}
+
+func (d Diagnostic) Error() string {
+	l := d.span.start
+	if d.severity == severityWarning {
+		return fmt.Sprintf("%s:%d:%d: warning: %s", l.File, l.Line, l.Col, d.message)
+	}
+	return fmt.Sprintf("%s:%d:%d: %s", l.File, l.Line, l.Col, d.message)
+}
+
+func (d Diagnostic) WithLabel(span Span, message string) Diagnostic {
+	d.labels = append(slices.Clone(d.labels), Label{span: span, message: message})
+	return d
+}
+
+func (d Diagnostic) WithNote(note string) Diagnostic {
+	d.notes = append(slices.Clone(d.notes), note)
+	return d
+}
+
+// a suggestion of how to fix the error
+func (d Diagnostic) WithHelp(help string) Diagnostic {
+	d.help = append(slices.Clone(d.help), help)
+	return d
+}
+
+// ErrorList collects the errors found while compiling
+// so they can all be reported at once
+type ErrorList []error
+
+func (l *ErrorList) Add(err error) {
+	switch e := err.(type) {
+	case nil:
+	case ErrorList:
+		*l = append(*l, e...)
+	default:
+		*l = append(*l, e)
+	}
+}
+
+// nil if there weren't any errors otherwise the errors in order of where
+// they are in the source. errors without a position come first
+func (l ErrorList) Err() error {
+	if len(l) == 0 {
+		return nil
+	}
+
+	sorted := slices.Clone(l)
+	slices.SortStableFunc(sorted, func(a error, b error) int {
+		aDiag, aPositioned := a.(Diagnostic)
+		bDiag, bPositioned := b.(Diagnostic)
+		if !aPositioned || !bPositioned {
+			// only errors with a position are moved
+			if aPositioned == bPositioned {
+				return 0
+			}
+			if bPositioned {
+				return -1
+			}
+			return 1
+		}
+		aStart, bStart := aDiag.span.start, bDiag.span.start
+		return cmp.Or(
+			cmp.Compare(aStart.File, bStart.File),
+			cmp.Compare(aStart.Line, bStart.Line),
+			cmp.Compare(aStart.Col, bStart.Col),
+		)
+	})
+	return sorted
+}
+
+// whether any of the diagnostics are errors rather than warnings
+func (l ErrorList) HasErrors() bool {
+	for _, err := range l {
+		if d, ok := err.(Diagnostic); !ok || d.severity == severityError {
+			return true
+		}
+	}
+	return false
+}
+
+func (l ErrorList) Error() string {
+	messages := []string{}
+	for _, err := range l {
+		messages = append(messages, err.Error())
+	}
+	return strings.Join(messages, "\n")
+}
+
+// E.G. "1 value" or "2 values"
+func plural(n int, word string) string {
+	if n == 1 {
+		return fmt.Sprintf("%d %s", n, word)
+	}
+	return fmt.Sprintf("%d %ss", n, word)
+}
+
+// the candidate closest to a misspelt name if any are close enough to be
+// what was meant. closeness is the number of single character edits apart
+func closestName(name string, candidates []string) (string, bool) {
+	best := ""
+	bestDistance := max(len([]rune(name))/3, 1) + 1
+	for _, c := range candidates {
+		if d := editDistance(name, c); d < bestDistance {
+			best = c
+			bestDistance = d
+		}
+	}
+	return best, best != ""
+}
+
+func editDistance(a string, b string) int {
+	aRunes, bRunes := []rune(a), []rune(b)
+
+	previous := make([]int, len(bRunes)+1)
+	for j := range previous {
+		previous[j] = j
+	}
+	for i := 1; i <= len(aRunes); i++ {
+		current := make([]int, len(bRunes)+1)
+		current[0] = i
+		for j := 1; j <= len(bRunes); j++ {
+			substitution := previous[j-1]
+			if aRunes[i-1] != bRunes[j-1] {
+				substitution++
+			}
+			current[j] = min(previous[j]+1, current[j-1]+1, substitution)
+		}
+		previous = current
+	}
+	return previous[len(bRunes)]
+}
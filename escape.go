@@ -0,0 +1,304 @@
+package main
+
+import (
+	"cmp"
+	"fmt"
+	"maps"
+	"slices"
+)
+
+/*
+	A function's parameters and variables are on its stack frame which is
+	reused once it returns, so a pointer to one of them can't outlive the
+	call. The escape check follows every path through a function keeping
+	track of which locals' addresses each variable might hold and reports
+	an address that's returned or written through a pointer that could be
+	to memory outliving the function, like the heap or the caller's frame.
+
+	Pointer arithmetic keeps pointing into the same local. Addresses passed
+	to calls or loaded back out of memory aren't followed.
+*/
+
+// the locals whose addresses a value might be, each with where its address was taken
+type addresses map[*Variable]Token
+
+// the locals in the order their addresses were taken
+func (a addresses) sorted() []*Variable {
+	locals := slices.Collect(maps.Keys(a))
+	slices.SortFunc(locals, func(x *Variable, y *Variable) int {
+		return cmp.Or(
+			cmp.Compare(a[x].lineInfo.Line, a[y].lineInfo.Line),
+			cmp.Compare(a[x].lineInfo.Col, a[y].lineInfo.Col),
+		)
+	})
+	return locals
+}
+
+// E.G. "local variable 'x'" or "parameter 'a'"
+func describeLocal(local *Variable) string {
+	if local.isParameter {
+		return fmt.Sprintf("parameter '%s'", local.name)
+	}
+	return fmt.Sprintf("local variable '%s'", local.name)
+}
+
+type escapeState struct {
+	pointsTo map[*Variable]addresses
+
+	// no path reaches here
+	unreachable bool
+}
+
+func newEscapeState() escapeState {
+	return escapeState{pointsTo: map[*Variable]addresses{}}
+}
+
+func unreachableEscapeState() escapeState {
+	state := newEscapeState()
+	state.unreachable = true
+	return state
+}
+
+func (s escapeState) clone() escapeState {
+	cloned := escapeState{pointsTo: map[*Variable]addresses{}, unreachable: s.unreachable}
+	for v, a := range s.pointsTo {
+		cloned.pointsTo[v] = maps.Clone(a)
+	}
+	return cloned
+}
+
+// the state where two paths join. a variable might hold
+// any address it might hold on either path
+func (s escapeState) join(other escapeState) escapeState {
+	if s.unreachable {
+		return other.clone()
+	}
+	if other.unreachable {
+		return s.clone()
+	}
+
+	joined := s.clone()
+	for v, a := range other.pointsTo {
+		if joined.pointsTo[v] == nil {
+			joined.pointsTo[v] = addresses{}
+		}
+		maps.Copy(joined.pointsTo[v], a)
+	}
+	return joined
+}
+
+func (s escapeState) equal(other escapeState) bool {
+	if s.unreachable != other.unreachable || len(s.pointsTo) != len(other.pointsTo) {
+		return false
+	}
+	for v, a := range s.pointsTo {
+		b, ok := other.pointsTo[v]
+		if !ok || len(a) != len(b) {
+			return false
+		}
+		for local := range a {
+			if _, ok := b[local]; !ok {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+type escapeChecker struct {
+	function *Function
+
+	// the states at each break and continue of the loops being checked, innermost last
+	breaks    [][]escapeState
+	continues [][]escapeState
+
+	// loops are checked until nothing changes so the same escape can be
+	// found more than once. they're only reported once for each position
+	errors map[LineInfo]Diagnostic
+}
+
+func checkEscapes(stmt NodeStmtFunctionDefinition) ErrorList {
+	c := escapeChecker{function: stmt.function, errors: map[LineInfo]Diagnostic{}}
+	c.checkStmts(stmt.body.stmts, newEscapeState())
+
+	errs := ErrorList{}
+	for _, err := range c.errors {
+		errs.Add(err)
+	}
+	return errs
+}
+
+func (c *escapeChecker) checkStmts(stmts []NodeStmt, state escapeState) escapeState {
+	for _, stmt := range stmts {
+		state = c.checkStmt(stmt, state)
+	}
+	return state
+}
+
+func (c *escapeChecker) checkStmt(rawStmt NodeStmt, state escapeState) escapeState {
+	switch stmt := rawStmt.(type) {
+	case NodeStmtVarDeclare:
+		state.pointsTo[stmt.variable] = addresses{}
+		if stmt.expr.HasValue() {
+			state.pointsTo[stmt.variable] = c.addressesOf(stmt.expr.MustGetValue(), state)
+		}
+		return state
+
+	case NodeStmtVarAssign:
+		state.pointsTo[stmt.variable] = c.addressesOf(stmt.expr, state)
+		return state
+
+	case NodeStmtPointerAssign:
+		value := c.addressesOf(stmt.expr, state)
+		if len(value) == 0 {
+			return state
+		}
+
+		// writing through a pointer to a local puts the
+		// address in that local, which is just as short lived
+		targets := state.pointsTo[stmt.variable]
+		if len(targets) == 0 {
+			for _, local := range value.sorted() {
+				c.escape(exprToken(stmt.expr), local, value[local], fmt.Sprintf("address of %s is written through '%s' which might outlive the function", describeLocal(local), stmt.variable.name))
+			}
+			return state
+		}
+		for target := range targets {
+			if state.pointsTo[target] == nil {
+				state.pointsTo[target] = addresses{}
+			}
+			maps.Copy(state.pointsTo[target], value)
+		}
+		return state
+
+	case NodeScope:
+		return c.checkStmts(stmt.stmts, state)
+
+	case NodeStmtIf:
+		return c.checkIf(stmt, state)
+
+	case NodeStmtWhile:
+		return c.checkWhile(stmt, state)
+
+	case NodeStmtBreak:
+		c.breaks[len(c.breaks)-1] = append(c.breaks[len(c.breaks)-1], state)
+		return unreachableEscapeState()
+
+	case NodeStmtContinue:
+		c.continues[len(c.continues)-1] = append(c.continues[len(c.continues)-1], state)
+		return unreachableEscapeState()
+
+	case NodeStmtReturn:
+		for _, r := range stmt.returns {
+			value := c.addressesOf(r, state)
+			for _, local := range value.sorted() {
+				c.escape(exprToken(r), local, value[local], fmt.Sprintf("returning the address of %s", describeLocal(local)))
+			}
+		}
+		return unreachableEscapeState()
+
+	case NodeStmtSyscall:
+		if !stmtFallsThrough(stmt) {
+			return unreachableEscapeState()
+		}
+		return state
+
+	default:
+		return state
+	}
+}
+
+func (c *escapeChecker) checkIf(stmt NodeStmtIf, state escapeState) escapeState {
+	then := c.checkStmts(stmt.scope.stmts, state.clone())
+	if !stmt.elseBranch.HasValue() {
+		return then.join(state)
+	}
+
+	switch _else := stmt.elseBranch.MustGetValue().(type) {
+	case NodeElseScope:
+		return then.join(c.checkStmts(_else.scope.stmts, state))
+	case NodeElseElif:
+		return then.join(c.checkIf(_else.ifStmt, state))
+	default:
+		panic(fmt.Errorf("escape error: don't know how to check else branch: %T", _else))
+	}
+}
+
+// the body is checked again with what it could have left behind
+// from the last time round until that doesn't change anything
+func (c *escapeChecker) checkWhile(stmt NodeStmtWhile, state escapeState) escapeState {
+	for {
+		c.breaks = append(c.breaks, []escapeState{})
+		c.continues = append(c.continues, []escapeState{})
+		end := c.checkStmts(stmt.scope.stmts, state.clone())
+		breaks := c.breaks[len(c.breaks)-1]
+		continues := c.continues[len(c.continues)-1]
+		c.breaks = c.breaks[:len(c.breaks)-1]
+		c.continues = c.continues[:len(c.continues)-1]
+
+		next := state.join(end)
+		for _, s := range continues {
+			next = next.join(s)
+		}
+		if !next.equal(state) {
+			state = next
+			continue
+		}
+
+		// an infinite loop is only left by breaking out of it
+		after := state
+		if value, constant := constantValue(stmt.expr); constant && value != 0 {
+			after = unreachableEscapeState()
+		}
+		for _, b := range breaks {
+			after = after.join(b)
+		}
+		return after
+	}
+}
+
+// the locals whose addresses an expression might be
+func (c *escapeChecker) addressesOf(rawExpr NodeExpr, state escapeState) addresses {
+	switch expr := rawExpr.(type) {
+	case NodeBinExprAdd:
+		return c.addressesOfSides(expr.left, expr.right, state)
+	case NodeBinExprSubtract:
+		return c.addressesOfSides(expr.left, expr.right, state)
+
+	case NodeTermIdentifier:
+		if v, ok := expr.symbol.(*Variable); ok {
+			return maps.Clone(state.pointsTo[v])
+		}
+		return addresses{}
+
+	case NodeTermRoundBracketExpr:
+		return c.addressesOf(expr.expr, state)
+
+	case NodeTermPointer:
+		if v, ok := expr.symbol.(*Variable); ok {
+			return addresses{v: expr.identifier}
+		}
+		return addresses{}
+
+	default:
+		return addresses{}
+	}
+}
+
+func (c *escapeChecker) addressesOfSides(left NodeExpr, right NodeExpr, state escapeState) addresses {
+	a := c.addressesOf(left, state)
+	maps.Copy(a, c.addressesOf(right, state))
+	return a
+}
+
+func (c *escapeChecker) escape(at Token, local *Variable, taken Token, message string) {
+	if _, reported := c.errors[at.lineInfo]; reported {
+		return
+	}
+	err := at.PositionedError(codeEscapingPointer, message).
+		WithNote(fmt.Sprintf("'%s' is on the stack frame of '%s' which is reused once it returns", local.name, c.function.name))
+	if taken.lineInfo != at.lineInfo {
+		err = err.WithLabel(taken.span(), "address taken here")
+	}
+	c.errors[at.lineInfo] = err
+}
@@ -0,0 +1,276 @@
+package main
+
+import (
+	"fmt"
+)
+
+/*
+	Constant folding works out at compile time what it can of the IR.
+	Binary instructions on constants become the value they give, and
+	temps known to be constant are replaced by the constant where
+	they're used. Branches on a constant become jumps.
+
+	Locals are only followed while they're stored and loaded in straight
+	line code, carried on into a block when it has just the one block
+	before it. Locals whose address is taken or which inline assembly
+	uses can change without a store so they're never known.
+
+	The arithmetic is the same as the assembly's: wrapping and with
+	unsigned division. Dividing by a constant 0 is an error as the
+	program would always crash there.
+*/
+
+type folder struct {
+	function *IRFunction
+
+	// locals that can only be changed by storing to them
+	followed map[*IRLocal]bool
+
+	constants map[IRTemp]IRConst
+	errors    ErrorList
+}
+
+func foldConstants(program *IRProgram) error {
+	errors := ErrorList{}
+	for _, function := range append(program.functions, program.start) {
+		errors.Add(foldFunction(function))
+	}
+	return errors.Err()
+}
+
+func foldFunction(function *IRFunction) error {
+	f := folder{
+		function:  function,
+		followed:  map[*IRLocal]bool{},
+		constants: map[IRTemp]IRConst{},
+	}
+
+	for _, local := range append(function.params, function.locals...) {
+		f.followed[local] = true
+	}
+	for _, b := range function.blocks {
+		for _, rawInstr := range b.instrs {
+			switch instr := rawInstr.(type) {
+			case IRAddressOf:
+				f.followed[instr.local] = false
+			case IRAsm:
+				for _, local := range instr.operands {
+					f.followed[local] = false
+				}
+			}
+		}
+	}
+
+	// a branch becoming a jump can leave blocks with one block before
+	// them that had more, so it's gone over again until none do
+	for folded := true; folded; {
+		folded = false
+		// errors are found again each time over
+		f.errors = ErrorList{}
+
+		// the constant locals at the end of each block
+		known := map[*IRBlock]map[*IRLocal]IRConst{}
+		for _, b := range function.blocks {
+			locals := map[*IRLocal]IRConst{}
+			if len(b.predecessors) == 1 {
+				for local, value := range known[b.predecessors[0]] {
+					locals[local] = value
+				}
+			}
+			if f.foldBlock(b, locals) {
+				folded = true
+			}
+			known[b] = locals
+		}
+		// blocks that can't be reached aren't counted as being before others
+		removeUnreachableBlocks(function)
+	}
+
+	removeUnusedInstrs(function)
+	return f.errors.Err()
+}
+
+func (f *folder) replace(value IRValue) IRValue {
+	if temp, ok := value.(IRTemp); ok {
+		if constant, ok := f.constants[temp]; ok {
+			return constant
+		}
+	}
+	return value
+}
+
+// whether the block's branch became a jump
+func (f *folder) foldBlock(b *IRBlock, locals map[*IRLocal]IRConst) bool {
+	for i, rawInstr := range b.instrs {
+		instr := replaceInstrUses(rawInstr, f.replace)
+
+		switch instr := instr.(type) {
+		case IRCopy:
+			if constant, ok := instr.src.(IRConst); ok {
+				f.constants[instr.dest] = constant
+			}
+
+		case IRBinary:
+			if value, ok := f.foldBinary(instr); ok {
+				f.constants[instr.dest] = value
+				b.instrs[i] = IRCopy{dest: instr.dest, src: value}
+				continue
+			}
+
+		case IRLoad:
+			if constant, ok := locals[instr.local]; ok {
+				f.constants[instr.dest] = constant
+				b.instrs[i] = IRCopy{dest: instr.dest, src: constant}
+				continue
+			}
+
+		case IRStore:
+			constant, ok := instr.value.(IRConst)
+			if ok && f.followed[instr.local] {
+				locals[instr.local] = constant
+			} else {
+				delete(locals, instr.local)
+			}
+		}
+		b.instrs[i] = instr
+	}
+
+	b.terminator = replaceTerminatorUses(b.terminator, f.replace)
+	if branch, ok := b.terminator.(IRBranch); ok {
+		if condition, ok := branch.condition.(IRConst); ok {
+			target := branch.otherwise
+			if condition != 0 {
+				target = branch.then
+			}
+			b.terminator = IRJump{target: target}
+			return true
+		}
+	}
+	return false
+}
+
+// the value of a binary instruction if both its operands are constant
+func (f *folder) foldBinary(instr IRBinary) (IRConst, bool) {
+	right, rightConstant := instr.right.(IRConst)
+	if rightConstant && right == 0 && (instr.op == irDivide || instr.op == irModulo) {
+		f.errors.Add(instr.position.PositionedError(codeDivideByZero, "division by zero"))
+		return 0, false
+	}
+
+	left, leftConstant := instr.left.(IRConst)
+	if !leftConstant || !rightConstant {
+		return 0, false
+	}
+
+	return evalBinaryOp(instr.op, left, right), true
+}
+
+// what the assembly gives for an operation on two values. the right
+// value can't be 0 for division as that's a crash rather than a value
+func evalBinaryOp(op IRBinaryOp, left IRConst, right IRConst) IRConst {
+	switch op {
+	case irAdd:
+		return left + right
+	case irSubtract:
+		return left - right
+	case irMultiply:
+		return left * right
+	case irDivide:
+		return IRConst(uint64(left) / uint64(right))
+	case irModulo:
+		return IRConst(uint64(left) % uint64(right))
+	default:
+		panic(fmt.Errorf("folding error: don't know how to fold: %s", irBinaryOpNames[op]))
+	}
+}
+
+// instructions that only assign a temp nothing uses and stores to
+// locals that are never read. dividing by a value that isn't known
+// is kept as it might be dividing by 0. locals left without
+// instructions using them are taken out of the function
+func removeUnusedInstrs(function *IRFunction) {
+	for changed := true; changed; {
+		changed = false
+
+		used := map[IRTemp]bool{}
+		read := map[*IRLocal]bool{}
+		for _, b := range function.blocks {
+			for _, rawInstr := range b.instrs {
+				for _, v := range instrUses(rawInstr) {
+					if temp, ok := v.(IRTemp); ok {
+						used[temp] = true
+					}
+				}
+
+				switch instr := rawInstr.(type) {
+				case IRLoad:
+					read[instr.local] = true
+				case IRAddressOf:
+					read[instr.local] = true
+				case IRAsm:
+					for _, local := range instr.operands {
+						read[local] = true
+					}
+				}
+			}
+			for _, v := range terminatorUses(b.terminator) {
+				if temp, ok := v.(IRTemp); ok {
+					used[temp] = true
+				}
+			}
+		}
+
+		for _, b := range function.blocks {
+			kept := []IRInstr{}
+			for _, rawInstr := range b.instrs {
+				removable := false
+				switch instr := rawInstr.(type) {
+				case IRCopy, IRLoad, IRAddressOf, IRFunctionAddress, IRLoadGlobal, IRVaCount:
+					removable = true
+				case IRBinary:
+					_, constantDivisor := instr.right.(IRConst)
+					removable = constantDivisor || (instr.op != irDivide && instr.op != irModulo)
+				case IRStore:
+					if !read[instr.local] {
+						changed = true
+						continue
+					}
+				}
+
+				dest := instrDest(rawInstr)
+				if removable && dest.HasValue() && !used[dest.MustGetValue()] {
+					changed = true
+					continue
+				}
+				kept = append(kept, rawInstr)
+			}
+			b.instrs = kept
+		}
+	}
+
+	referenced := map[*IRLocal]bool{}
+	for _, b := range function.blocks {
+		for _, rawInstr := range b.instrs {
+			switch instr := rawInstr.(type) {
+			case IRLoad:
+				referenced[instr.local] = true
+			case IRStore:
+				referenced[instr.local] = true
+			case IRAddressOf:
+				referenced[instr.local] = true
+			case IRAsm:
+				for _, local := range instr.operands {
+					referenced[local] = true
+				}
+			}
+		}
+	}
+	locals := []*IRLocal{}
+	for _, local := range function.locals {
+		if referenced[local] {
+			local.index = len(locals)
+			locals = append(locals, local)
+		}
+	}
+	function.locals = locals
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"slices"
+	"testing"
+)
+
+// the last syscall of the top level code is syscall(60, <value>)
+func TestFoldConstants(t *testing.T) {
+	tests := []struct {
+		name   string
+		source string
+		want   IRValue
+	}{
+		{"arithmetic", "syscall(60, 1 + 2 * 3 - 4);", IRConst(3)},
+		{"wrapping", "syscall(60, 0 - 1 + 2);", IRConst(1)},
+		{"unsigned division", "syscall(60, (0 - 2) / 2);", IRConst(0x7fffffffffffffff)},
+		{"unsigned modulo", "syscall(60, (0 - 1) % 10);", IRConst(5)},
+		{"locals", "var x = 3;\nvar y = x * 2;\nsyscall(60, y);", IRConst(6)},
+		{"after a known branch", "var x = 1;\nif (x - 1) { x = 5; }\nsyscall(60, x);", IRConst(1)},
+		{"address taken", "var x = 3;\nvar p = &x;\n*p = 4;\nsyscall(60, x);", nil},
+		{"used by asm", "var x = 3;\nasm { mov %x, 4 }\nsyscall(60, x);", nil},
+		{"after an unknown branch", "var x = syscall(39);\nvar y = 1;\nif (x) { y = 2; }\nsyscall(60, y);", nil},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ir := lowerSource(t, test.source)
+			if err := foldConstants(&ir); err != nil {
+				t.Fatal(err)
+			}
+
+			syscalls := findInstrs[IRSyscall](ir.start)
+			got := syscalls[len(syscalls)-1].args[1]
+			if _, isConst := got.(IRConst); test.want == nil && isConst {
+				t.Errorf("got %v, want a value that isn't known", got)
+			} else if test.want != nil && got != test.want {
+				t.Errorf("got %v, want %v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestFoldBranches(t *testing.T) {
+	ir := lowerSource(t, "if (2 - 2) { syscall(60, 1); }\nwhile (0) { syscall(60, 2); }\nsyscall(60, 3);")
+	if err := foldConstants(&ir); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, b := range ir.start.blocks {
+		if _, ok := b.terminator.(IRBranch); ok {
+			t.Errorf("branch on a constant left in %s", b.label)
+		}
+	}
+	if syscalls := findInstrs[IRSyscall](ir.start); len(syscalls) != 1 {
+		t.Errorf("got %d syscalls, want the branches' ones removed", len(syscalls))
+	}
+}
+
+func TestFoldErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		source string
+		want   []string
+	}{
+		{"divide by zero", "var x = 5 / (3 - 3);", []string{codeDivideByZero}},
+		{"modulo by zero", "var x = 5;\nvar y = x % 0;", []string{codeDivideByZero}},
+		{"every one", "var x = 1 / 0;\nvar y = 1 % 0;", []string{codeDivideByZero, codeDivideByZero}},
+		{"unknown divisor", "var x = syscall(39);\nvar y = 5 / x;", []string{}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ir := lowerSource(t, test.source)
+			err := foldConstants(&ir)
+			got := []string{}
+			if err != nil {
+				got = errorCodes(err)
+			}
+			if !slices.Equal(got, test.want) {
+				t.Errorf("got %v, want %v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestRemoveUnusedInstrs(t *testing.T) {
+	// the division by a value that isn't known is kept as it might crash
+	ir := lowerSource(t, "var x = syscall(39);\nvar y = x + 1;\nvar z = 5 / x;\nsyscall(60, 0);")
+	if err := foldConstants(&ir); err != nil {
+		t.Fatal(err)
+	}
+
+	binaries := findInstrs[IRBinary](ir.start)
+	if len(binaries) != 1 || binaries[0].op != irDivide {
+		t.Errorf("got %v, want only the division", binaries)
+	}
+	// y and z are never read
+	if stores := findInstrs[IRStore](ir.start); len(stores) != 1 || stores[0].local.name != "x" {
+		t.Errorf("got %v, want only the store to x", stores)
+	}
+}
@@ -3,39 +3,49 @@ package main
 import (
 	"fmt"
 	"slices"
-	"strconv"
+	"strings"
+	"unicode"
+
+	opt "github.com/moltenwolfcub/moltenCompiler/optional"
 )
 
+/*
+	The generator turns the IR into assembly. Each function has a frame
+	below rbp with a slot for every local and then every temporary so
+	instructions load their operands from the frame into registers and
+	store their result back.
+
+	Arguments are pushed by the caller from the last to the first above
+	a slot for each return value so a function's parameters and return
+	slots are above rbp. Variadic functions have the number of variadic
+	arguments passed pushed last.
+*/
+
 type Generator struct {
-	program NodeProg
+	ir IRProgram
+
+	// the function being generated and where its values are
+	function   *IRFunction
+	allocation allocation
+
+	runtimeErrors []RuntimeError
 
-	stackSize uint
-	variables []Variable
-	functions []Function
-	scopes    []int
+	usesHeap  bool
+	debugHeap bool
 
-	labelCount    int
-	breakLabel    string
-	continueLabel string
+	// qwords in the bss section
+	globals []string
 
-	inFunc          bool
-	currentFunction Function
+	usesProcessArgs bool
 
 	genASMComments bool
+	// 0 writes out the assembly as it's generated
+	optimisationLevel int
 }
 
-func NewGenerator(prog NodeProg) Generator {
+func NewGenerator(ir IRProgram) Generator {
 	return Generator{
-		program: prog,
-
-		stackSize: 0,
-		variables: []Variable{},
-		functions: []Function{},
-		scopes:    []int{},
-
-		labelCount:    0,
-		breakLabel:    "nil",
-		continueLabel: "nil",
+		ir: ir,
 
 		genASMComments: true,
 	}
@@ -44,751 +54,643 @@ func NewGenerator(prog NodeProg) Generator {
 func (g *Generator) GenProg() (string, error) {
 	output := "global _start\n\n\n"
 
-	pre, err := g.PreGenerate()
-	if err != nil {
-		return "", err
-	}
-	output += pre
-
-	output += "_start:\n"
-
-	for _, stmt := range g.program.stmts {
-		generated, err := g.GenStmt(stmt)
-		if err != nil {
-			return "", err
-		}
-		output += generated + "\n"
-	}
-
-	//exit 0 at end of program if no explicit exit called
-	output += "\tmov rax, 60\n"
-	output += "\tmov rdi, 0\n"
-	output += "\tsyscall\n"
-
-	return output, nil
-}
-
-func (g *Generator) PreGenerate() (string, error) {
-	output := ""
-
-	for _, stmt := range g.program.stmts {
-		funcStmt, ok := stmt.(NodeStmtFunctionDefinition)
-		if !ok {
-			continue
-		}
-		generated, err := g.GenFuncDefinition(funcStmt)
+	for _, f := range g.ir.functions {
+		generated, err := g.GenFunction(f)
 		if err != nil {
 			return "", err
 		}
-		output += generated + "\n\n"
+		output += asmLinesString(generated) + "\n\n"
 	}
-	return output, nil
-}
-
-func (g *Generator) GenFuncDefinition(stmt NodeStmtFunctionDefinition) (string, error) {
-	g.inFunc = true
-	output := ""
-
-	functionName := stmt.ident.value.MustGetValue()
 
-	returnCount, err := strconv.Atoi(stmt.returns)
+	start, err := g.GenFunction(g.ir.start)
 	if err != nil {
 		return "", err
 	}
+	output += asmLinesString(start)
 
-	for _, f := range g.functions {
-		if f.name == functionName && f.returnCount == len(stmt.returns) {
-			return "", stmt.ident.lineInfo.PositionedError(fmt.Sprintf("function identifier already used: %v", functionName))
-		}
+	if g.usesHeap {
+		output += "\n" + g.GenHeapRuntime()
 	}
-	g.currentFunction = Function{name: functionName, parameters: len(stmt.params), returnCount: returnCount}
-
-	output += fmt.Sprintf("%s_%d:\n", functionName, len(stmt.params))
 
-	if g.genASMComments {
-		output += "\t;=====FUNCTION SETUP=====\n"
+	for _, runtimeErr := range g.runtimeErrors {
+		output += "\n" + g.GenRuntimeError(runtimeErr)
 	}
-	output += g.push("rbp")
-	output += "\tmov rbp, rsp\n\n"
 
-	parameters := []Variable{}
-	for i, p := range stmt.params {
-		v := Variable{
-			isParameter: true,
-			name:        p.value.MustGetValue(),
-			stackLoc:    uint(i + 2),
+	if len(g.globals) > 0 {
+		output += "\nsection .bss\n"
+		for _, global := range g.globals {
+			output += global + ": resq 1\n"
 		}
-		parameters = append(parameters, v)
-		g.stackSize++
 	}
 
-	body, err := g.GenFunctionBody(stmt.body, parameters)
-	if err != nil {
-		return "", err
-	}
-	output += body
-
-	if g.genASMComments {
-		output += "\t;=====FUNCTION CLEANUP=====\n"
-	}
-
-	output += g.pop("rbp")
-
-	output += "\tret\n"
-
-	g.inFunc = false
-	g.functions = append(g.functions, g.currentFunction)
-	g.currentFunction = Function{}
-	g.stackSize = 0
-
 	return output, nil
 }
 
-func (g *Generator) GenStmt(rawStmt NodeStmt) (string, error) {
-	output := ""
-
-	switch stmt := rawStmt.(type) {
-	case NodeStmtVarDeclare:
-		variableName := stmt.ident.value.MustGetValue()
-
-		for _, v := range g.variables {
-			if v.name == variableName {
-				return "", stmt.ident.lineInfo.PositionedError(fmt.Sprintf("variable identifier already used: %v", variableName))
-			}
-		}
+func (g *Generator) reserveGlobal(label string) {
+	if !slices.Contains(g.globals, label) {
+		g.globals = append(g.globals, label)
+	}
+}
 
-		g.variables = append(g.variables, Variable{stackLoc: g.stackSize, name: variableName})
-		output += "\tmov rax, 0\n" //set a default starting value
-		output += g.push("rax")
-
-	case NodeStmtVarAssign:
-		variableName := stmt.ident.value.MustGetValue()
-		var variable Variable
-		exists := false
-		for _, v := range g.variables {
-			if v.name == variableName {
-				variable = v
-				exists = true
-				break
-			}
-		}
-		if !exists {
-			return "", stmt.ident.lineInfo.PositionedError(fmt.Sprintf("undefined variable: '%s'", variableName))
-		}
+func (g *Generator) comment(text string) []AsmLine {
+	if !g.genASMComments {
+		return []AsmLine{}
+	}
+	return []AsmLine{AsmComment{text: text}}
+}
 
-		expr, err := g.GenExpr(stmt.expr)
-		if err != nil {
-			return "", err
-		}
-		output += expr
-		output += g.pop("rax")
-		if variable.isParameter {
-			output += fmt.Sprintf("\tmov QWORD [rbp + %v], rax\n", variable.stackLoc*8)
-		} else {
-			output += fmt.Sprintf("\tmov QWORD [rsp + %v], rax\n", (g.stackSize-variable.stackLoc-1)*8)
-		}
+func (g *Generator) GenFunction(function *IRFunction) ([]AsmLine, error) {
+	g.function = function
+	g.allocation = allocateRegisters(function)
+	defer func() { g.function = nil }()
 
-	case NodeStmtPointerAssign:
-		variableName := stmt.ident.value.MustGetValue()
-		var variable Variable
-		exists := false
-		for _, v := range g.variables {
-			if v.name == variableName {
-				variable = v
-				exists = true
-				break
-			}
-		}
-		if !exists {
-			return "", stmt.ident.lineInfo.PositionedError(fmt.Sprintf("undefined variable: '%s'", variableName))
+	body := []AsmLine{}
+	for i, block := range function.blocks {
+		var next *IRBlock
+		if i+1 < len(function.blocks) {
+			next = function.blocks[i+1]
 		}
 
-		expr, err := g.GenExpr(stmt.expr)
+		generated, err := g.GenBlock(block, next)
 		if err != nil {
-			return "", err
-		}
-		output += expr
-		output += g.pop("rax")
-		if variable.isParameter {
-			output += fmt.Sprintf("\tmov rbx, [rbp + %v]\n", variable.stackLoc*8)
-		} else {
-			output += fmt.Sprintf("\tmov rbx, [rsp + %v]\n", (g.stackSize-variable.stackLoc-1)*8)
+			return nil, err
 		}
-		output += "\tmov QWORD [rbx], rax\n"
+		body = append(body, generated...)
+	}
 
-	case NodeScope:
-		scope, err := g.GenScope(stmt)
-		if err != nil {
-			return "", err
-		}
-		output += scope
-	case NodeStmtIf:
-		ifStmt, err := g.GenIf(stmt)
-		if err != nil {
-			return "", err
+	output := []AsmLine{}
+	if function.function == nil {
+		output = append(output, AsmLabel{name: "_start"})
+		// the process's arguments are where rsp starts so they're saved first
+		if g.usesProcessArgs {
+			output = append(output, g.GenProcessArgsCapture()...)
 		}
-		output += ifStmt
-
-	case NodeStmtWhile:
-		startLabel := g.createLabel("startWhile")
-		endLabel := g.createLabel("endWhile")
-
-		g.breakLabel = endLabel
-		g.continueLabel = startLabel
+	} else {
+		// header read by indirect calls to check the call matches the function
+		output = append(output, asmInstr("dq", fmt.Sprint(function.function.parameters), fmt.Sprint(function.function.returnCount)))
+		output = append(output, AsmLabel{name: function.label})
+		output = append(output, asmInstr("push", "rbp"))
+	}
 
-		output += startLabel + ":\n"
+	output = append(output, g.comment("=====FUNCTION SETUP=====")...)
+	output = append(output, asmInstr("mov", "rbp", "rsp"))
+	output = append(output, asmInstr("sub", "rsp", fmt.Sprint(g.allocation.frameSize)))
+	for _, reg := range g.allocation.saved {
+		output = append(output, asmInstr("mov", g.savedOperand(reg), reg))
+	}
+	for _, p := range g.allocation.paramLoads {
+		output = append(output, g.move(g.location(localVar(p)), g.paramOperand(p))...)
+	}
+	for _, description := range g.allocation.describe(function) {
+		output = append(output, g.comment(description)...)
+	}
+	output = append(output, body...)
 
-		expr, err := g.GenExpr(stmt.expr)
-		if err != nil {
-			return "", err
-		}
-		output += expr
-		output += g.pop("rax")
+	if g.optimisationLevel >= 1 {
+		output = peephole(output)
+	}
+	return output, nil
+}
 
-		output += "\ttest rax, rax\n"
-		output += "\tjz " + endLabel + "\n"
+// next is the block after this one, which
+// doesn't need jumping to, or nil if it's the last
+func (g *Generator) GenBlock(block *IRBlock, next *IRBlock) ([]AsmLine, error) {
+	output := []AsmLine{AsmLabel{name: block.label}}
 
-		scope, err := g.GenScope(stmt.scope)
+	for _, instr := range block.instrs {
+		output = append(output, g.comment(g.function.instrString(instr))...)
+		generated, err := g.GenInstr(instr)
 		if err != nil {
-			return "", err
+			return nil, err
 		}
-		output += scope
+		output = append(output, generated...)
+	}
 
-		output += "\tjmp " + startLabel + "\n"
+	output = append(output, g.comment(g.function.terminatorString(block.terminator))...)
+	return append(output, g.GenTerminator(block.terminator, next)...), nil
+}
 
-		output += endLabel + ":\n"
+func (g *Generator) GenInstr(rawInstr IRInstr) ([]AsmLine, error) {
+	output := []AsmLine{}
 
-		g.breakLabel = "nil"
-		g.continueLabel = "nil"
+	switch instr := rawInstr.(type) {
+	case IRCopy:
+		output = append(output, g.load(g.location(tempVar(instr.dest)), instr.src)...)
 
-	case NodeStmtBreak:
-		if g.breakLabel == "nil" {
-			return "", stmt._break.lineInfo.PositionedError("can't break when not in a loop")
-		}
-		output += "\tjmp " + g.breakLabel + "\n"
+	case IRBinary:
+		output = append(output, g.GenBinary(instr)...)
 
-	case NodeStmtContinue:
-		if g.continueLabel == "nil" {
-			return "", stmt._continue.lineInfo.PositionedError("can't continue when not in a loop")
-		}
-		output += "\tjmp " + g.continueLabel + "\n"
+	case IRLoad:
+		output = append(output, g.move(g.location(tempVar(instr.dest)), g.location(localVar(instr.local)))...)
 
-	case NodeStmtFunctionDefinition:
-		/*
-			functions are generated before other statements
-			so they are in the correct order.
+	case IRStore:
+		output = append(output, g.load(g.location(localVar(instr.local)), instr.value)...)
 
-			this is just here to keep the type switch happy.
-		*/
+	case IRLoadPointer:
+		output = append(output, g.load("rax", instr.address)...)
+		output = append(output, asmInstr("mov", "rax", "[rax]"))
+		output = append(output, g.store(instr.dest, "rax")...)
 
-	case NodeFunctionCall:
-		funcCall, retCount, err := g.GenFuncCall(stmt)
-		if err != nil {
-			return "", err
-		}
-		output += funcCall
+	case IRStorePointer:
+		output = append(output, g.load("rax", instr.value)...)
+		output = append(output, g.load("rcx", instr.address)...)
+		output = append(output, asmInstr("mov", "QWORD [rcx]", "rax"))
 
-		// get rid of the return values as we're not storing them
-		output += "\tadd rsp, " + fmt.Sprintf("%d", retCount*8) + "\n"
-		g.stackSize -= uint(retCount)
+	case IRAddressOf:
+		output = append(output, asmInstr("lea", "rax", g.location(localVar(instr.local))))
+		output = append(output, g.store(instr.dest, "rax")...)
 
-	case NodeStmtReturn:
-		if !g.inFunc {
-			return "", stmt._return.lineInfo.PositionedError("can only return when in a function")
-		}
+	case IRFunctionAddress:
+		// the address of a function so it can be stored and called later
+		output = append(output, asmInstr("lea", "rax", "[rel "+instr.function.label()+"]"))
+		output = append(output, g.store(instr.dest, "rax")...)
 
-		if len(stmt.returns) != g.currentFunction.returnCount {
-			return "", stmt._return.lineInfo.PositionedError(fmt.Sprintf("incorrect number of values returned. Expected %v, Found %v", g.currentFunction.returnCount, len(stmt.returns)))
+	case IRLoadGlobal:
+		if instr.global == "molten_argc" || instr.global == "molten_argv" || instr.global == "molten_envp" {
+			g.usesProcessArgs = true
 		}
+		g.reserveGlobal(instr.global)
+		output = append(output, asmInstr("mov", "rax", "[rel "+instr.global+"]"))
+		output = append(output, g.store(instr.dest, "rax")...)
 
-		for i, expr := range stmt.returns {
-			expr, err := g.GenExpr(expr)
-			if err != nil {
-				return "", err
-			}
-			output += expr
+	case IRCall:
+		output = append(output, g.GenCall(instr)...)
 
-			stackOffset := (g.currentFunction.parameters + i + 2) * 8
+	case IRCallIndirect:
+		output = append(output, g.GenIndirectCall(instr)...)
 
-			output += g.pop(fmt.Sprintf("QWORD [rbp + %v]", stackOffset))
-		}
+	case IRSyscall:
+		output = append(output, g.GenSyscall(instr)...)
 
-		output += g.exitFunction(false)
-		output += "\tpop rbp\n" //g.pop("rbp")
-		output += "\tret\n"
+	case IRVaCount, IRVaArg:
+		output = append(output, g.GenVariadicBuiltin(instr)...)
 
-	case NodeStmtSyscall:
-		argRegisters := []string{"rax", "rdi", "rsi", "rdx", "r10", "r8", "r9"}
-		usedArgs := len(stmt.arguments)
+	case IRRuntimeCall:
+		output = append(output, g.GenRuntimeCall(instr)...)
 
-		for _, e := range stmt.arguments {
-			expr, err := g.GenExpr(e)
-			if err != nil {
-				return "", err
-			}
-			output += expr
-		}
-
-		for i := usedArgs - 1; i >= 0; i-- {
-			output += g.pop(argRegisters[i])
+	case IRAsm:
+		asm, err := g.GenAsm(instr)
+		if err != nil {
+			return nil, err
 		}
-		output += "\tsyscall\n"
+		output = append(output, asm...)
 
 	default:
-		panic(fmt.Errorf("generator error: don't know how to generate statement: %T", rawStmt))
+		panic(fmt.Errorf("generator error: don't know how to generate instruction: %T", rawInstr))
 	}
 	return output, nil
 }
 
-func (g *Generator) GenFuncCall(stmt NodeFunctionCall) (string, int, error) {
-	output := ""
-
-	functionName := stmt.ident.value.MustGetValue()
-	var function Function
-	exists := false
-	foundWrong := false
-
-	allFunctions := append(g.functions, g.currentFunction)
-	for _, f := range allFunctions {
-		if f.name == functionName {
-			exists = true
-			if len(stmt.params) == f.parameters {
-				function = f
-				foundWrong = false
-				break
-			}
-			foundWrong = true
-		}
-	}
-	if !exists {
-		return "", 0, stmt.ident.lineInfo.PositionedError(fmt.Sprintf("undefined function: '%s'", functionName))
-	}
-	if foundWrong {
-		return "", 0, stmt.ident.lineInfo.PositionedError("incorrect number of arguments passed.")
-	}
+func (g *Generator) GenBinary(instr IRBinary) []AsmLine {
+	output := []AsmLine{}
+	dest := g.location(tempVar(instr.dest))
 
-	for i := 0; i < function.returnCount; i++ {
-		output += g.push("0")
-	}
-
-	reversed := stmt.params
-	slices.Reverse(reversed)
-	for _, p := range reversed {
-		expr, err := g.GenExpr(p)
-		if err != nil {
-			return "", 0, err
+	switch instr.op {
+	case irAdd, irSubtract:
+		op := "add"
+		if instr.op == irSubtract {
+			op = "sub"
 		}
-		output += expr
-	}
-
-	output += fmt.Sprintf("\tcall %s_%d\n", function.name, function.parameters)
 
-	output += "\tadd rsp, " + fmt.Sprintf("%d", len(stmt.params)*8) + "\n"
-	g.stackSize -= uint(len(stmt.params))
+		code, right := g.operand(instr.right, "rcx")
+		output = append(output, code...)
 
-	// Must deal with cleaning up return values from go-stack at function call-site
-
-	return output, function.returnCount, nil
-}
-
-func (g *Generator) GenExpr(rawExpr NodeExpr) (string, error) {
-	output := ""
-
-	switch expr := rawExpr.(type) {
-	case NodeTerm:
-		term, err := g.GenTerm(expr)
-		if err != nil {
-			return "", err
+		// worked out in the destination if loading the left side there keeps the right
+		result := "rax"
+		if isRegister(dest) && dest != right {
+			result = dest
 		}
-		output += term
-	case NodeBinExpr:
-		binExpr, err := g.GenBinExpr(expr)
-		if err != nil {
-			return "", err
-		}
-		output += binExpr
-	default:
-		panic(fmt.Errorf("generator error: don't know how to generate expression: %T", rawExpr))
-	}
-	return output, nil
-}
+		output = append(output, g.load(result, instr.left)...)
+		output = append(output, asmInstr(op, result, right))
+		output = append(output, g.move(dest, result)...)
 
-func (g *Generator) GenBinExpr(rawBinExpr NodeBinExpr) (string, error) {
-	output := ""
-	switch binExpr := rawBinExpr.(type) {
-	case NodeBinExprAdd:
-		expr, err := g.GenExpr(binExpr.left)
-		if err != nil {
-			return "", err
-		}
-		output += expr
-		expr, err = g.GenExpr(binExpr.right)
-		if err != nil {
-			return "", err
-		}
+	case irMultiply, irDivide, irModulo:
+		output = append(output, g.load("rax", instr.left)...)
+		output = append(output, g.load("rcx", instr.right)...)
 
-		output += expr
-		output += g.pop("rbx")
-		output += g.pop("rax")
-		output += "\tadd rax, rbx\n"
-		output += g.push("rax")
-	case NodeBinExprSubtract:
-		expr, err := g.GenExpr(binExpr.left)
-		if err != nil {
-			return "", err
-		}
-		output += expr
-		expr, err = g.GenExpr(binExpr.right)
-		if err != nil {
-			return "", err
-		}
-		output += expr
-
-		output += g.pop("rbx")
-		output += g.pop("rax")
-		output += "\tsub rax, rbx\n"
-		output += g.push("rax")
-	case NodeBinExprMultiply:
-		expr, err := g.GenExpr(binExpr.left)
-		if err != nil {
-			return "", err
+		result := "rax"
+		switch instr.op {
+		case irMultiply:
+			output = append(output, asmInstr("mul", "rcx"))
+		case irDivide:
+			output = append(output, asmInstr("mov", "rdx", "0"))
+			output = append(output, asmInstr("div", "rcx"))
+		case irModulo:
+			output = append(output, asmInstr("mov", "rdx", "0"))
+			output = append(output, asmInstr("div", "rcx"))
+			result = "rdx"
 		}
-		output += expr
-		expr, err = g.GenExpr(binExpr.right)
-		if err != nil {
-			return "", err
-		}
-		output += expr
-
-		output += g.pop("rbx")
-		output += g.pop("rax")
-		output += "\tmul rbx\n"
-		output += g.push("rax")
-	case NodeBinExprDivide:
-		expr, err := g.GenExpr(binExpr.left)
-		if err != nil {
-			return "", err
-		}
-		output += expr
-		expr, err = g.GenExpr(binExpr.right)
-		if err != nil {
-			return "", err
-		}
-		output += expr
-
-		output += g.pop("rbx")
-		output += g.pop("rax")
-		output += "\tmov rdx, 0\n"
-		output += "\tdiv rbx\n"
-		output += g.push("rax")
-	case NodeBinExprModulo:
-		expr, err := g.GenExpr(binExpr.left)
-		if err != nil {
-			return "", err
-		}
-		output += expr
-		expr, err = g.GenExpr(binExpr.right)
-		if err != nil {
-			return "", err
-		}
-		output += expr
+		output = append(output, g.move(dest, result)...)
 
-		output += g.pop("rbx")
-		output += g.pop("rax")
-		output += "\tmov rdx, 0\n"
-		output += "\tdiv rbx\n"
-		output += g.push("rdx")
 	default:
-		panic(fmt.Errorf("generator error: don't know how to generate binary expression: %T", rawBinExpr))
+		panic(fmt.Errorf("generator error: don't know how to generate binary operation: %d", instr.op))
 	}
-	return output, nil
+	return output
 }
 
-func (g *Generator) GenTerm(rawTerm NodeTerm) (string, error) {
-	output := ""
-
-	switch term := rawTerm.(type) {
-	case NodeTermIntLiteral:
-		output += "\tmov rax, " + term.intLiteral.value.MustGetValue() + "\n"
-		output += g.push("rax")
-
-	case NodeTermIdentifier:
-		variableName := term.identifier.value.MustGetValue()
+func (g *Generator) GenTerminator(rawTerminator IRTerminator, next *IRBlock) []AsmLine {
+	output := []AsmLine{}
 
-		var variable Variable
-		exists := false
-		for _, v := range g.variables {
-			if v.name == variableName {
-				variable = v
-				exists = true
-			}
-		}
+	switch terminator := rawTerminator.(type) {
+	case IRJump:
+		output = append(output, asmInstr("jmp", terminator.target.label))
 
-		if !exists {
-			return "", term.identifier.lineInfo.PositionedError(fmt.Sprintf("undefined variable: %v", variableName))
-		}
-
-		if variable.isParameter {
-			output += g.push(fmt.Sprintf("QWORD [rbp + %v]", variable.stackLoc*8))
+	case IRBranch:
+		condition := "rax"
+		if temp, ok := terminator.condition.(IRTemp); ok && isRegister(g.location(tempVar(temp))) {
+			condition = g.location(tempVar(temp))
 		} else {
-			output += g.push(fmt.Sprintf("QWORD [rsp + %v]", (g.stackSize-variable.stackLoc-1)*8))
-		}
-
-	case NodeFunctionCall:
-		funcCall, retCount, err := g.GenFuncCall(term)
-		if err != nil {
-			return "", err
-		}
-
-		if retCount != 1 {
-			return "", term.ident.lineInfo.PositionedError("function doesn't return any values (or more than 1 atm) so can't be used as a term")
+			output = append(output, g.load("rax", terminator.condition)...)
+		}
+		output = append(output, asmInstr("test", condition, condition))
+		if terminator.otherwise == next {
+			output = append(output, asmInstr("jnz", terminator.then.label))
+			break
+		}
+		output = append(output, asmInstr("jz", terminator.otherwise.label))
+		output = append(output, asmInstr("jmp", terminator.then.label))
+
+	case IRReturn:
+		function := g.function.function
+		for i, value := range terminator.values {
+			output = append(output, g.load("rax", value)...)
+
+			stackOffset := (function.parameters + i + function.firstParamLoc()) * 8
+			if function.variadic {
+				// return slots sit above however many variadic arguments were passed
+				output = append(output, asmInstr("mov", "rcx", "[rbp + 16]"))
+				output = append(output, asmInstr("mov", fmt.Sprintf("QWORD [rbp + rcx*8 + %d]", stackOffset), "rax"))
+			} else {
+				output = append(output, asmInstr("mov", fmt.Sprintf("QWORD [rbp + %d]", stackOffset), "rax"))
+			}
 		}
 
-		output += funcCall
-
-	case NodeTermRoundBracketExpr:
-		expr, err := g.GenExpr(term.expr)
-		if err != nil {
-			return "", err
+		output = append(output, g.comment("=====FUNCTION CLEANUP=====")...)
+		for _, reg := range g.allocation.saved {
+			output = append(output, asmInstr("mov", reg, g.savedOperand(reg)))
 		}
-		output += expr
+		output = append(output, asmInstr("mov", "rsp", "rbp"))
+		output = append(output, asmInstr("pop", "rbp"))
+		output = append(output, asmInstr("ret"))
 
-	case NodeTermPointer:
-		variableName := term.identifier.value.MustGetValue()
+	case IRExit:
+		output = append(output, g.load("rdi", terminator.code)...)
+		output = append(output, asmInstr("mov", "rax", "60"))
+		output = append(output, asmInstr("syscall"))
 
-		var variable Variable
-		exists := false
-		for _, v := range g.variables {
-			if v.name == variableName {
-				variable = v
-				exists = true
-			}
-		}
+	default:
+		panic(fmt.Errorf("generator error: don't know how to generate terminator: %T", rawTerminator))
+	}
+	return output
+}
 
-		if !exists {
-			return "", term.identifier.lineInfo.PositionedError(fmt.Sprintf("undefined variable: %v", variableName))
-		}
+func (g *Generator) GenCall(call IRCall) []AsmLine {
+	output := []AsmLine{}
 
-		if variable.isParameter {
-			output += "\tlea rax, QWORD [rbp + " + fmt.Sprint(variable.stackLoc*8) + "]\n"
-		} else {
-			output += "\tlea rax, QWORD [rsp + " + fmt.Sprint((g.stackSize-variable.stackLoc-1)*8) + "]\n"
-		}
-		output += g.push("rax")
-
-	case NodeTermPointerDereference:
-		variableName := term.identifier.value.MustGetValue()
+	for i := 0; i < call.function.returnCount; i++ {
+		output = append(output, asmInstr("push", "0"))
+	}
+	for i := len(call.args) - 1; i >= 0; i-- {
+		output = append(output, g.push(call.args[i])...)
+	}
 
-		var variable Variable
-		exists := false
-		for _, v := range g.variables {
-			if v.name == variableName {
-				variable = v
-				exists = true
-			}
-		}
+	argCount := len(call.args)
+	if call.function.variadic {
+		// hidden count of the variadic arguments
+		output = append(output, asmInstr("push", fmt.Sprint(len(call.args)-call.function.parameters)))
+		argCount++
+	}
 
-		if !exists {
-			return "", term.identifier.lineInfo.PositionedError(fmt.Sprintf("undefined variable: %v", variableName))
-		}
+	output = append(output, asmInstr("call", call.function.label()))
+	output = append(output, asmInstr("add", "rsp", fmt.Sprint(argCount*8)))
 
-		if variable.isParameter {
-			output += "\tmov rax, [rbp + " + fmt.Sprint(variable.stackLoc*8) + "]\n"
-		} else {
-			output += "\tmov rax, [rsp + " + fmt.Sprint((g.stackSize-variable.stackLoc-1)*8) + "]\n"
-		}
+	return append(output, g.takeReturns(call.dest, call.function.returnCount)...)
+}
 
-		output += "\tmov rax, [rax]\n"
+// calls whatever function address the callee is. As the return count of a
+// function value isn't known until runtime the function header is checked
+// against the call. A call used as a value expects one value and one used
+// as a statement throws away however many the function gives, making room
+// for them on the stack once the header has been read.
+func (g *Generator) GenIndirectCall(call IRCallIndirect) []AsmLine {
+	output := []AsmLine{}
+	badCall := g.runtimeErrorLabel("badCall", "indirect call doesn't match the arguments or returns of the function called")
+
+	if call.dest.HasValue() {
+		output = append(output, asmInstr("push", "0"))
+		for i := len(call.args) - 1; i >= 0; i-- {
+			output = append(output, g.push(call.args[i])...)
+		}
+		output = append(output, g.load("rax", call.callee)...)
+		output = append(output, asmInstr("cmp", "QWORD [rax - 16]", fmt.Sprint(len(call.args))))
+		output = append(output, asmInstr("jne", badCall))
+		output = append(output, asmInstr("cmp", "QWORD [rax - 8]", "1"))
+		output = append(output, asmInstr("jne", badCall))
+		output = append(output, asmInstr("call", "rax"))
+		output = append(output, asmInstr("add", "rsp", fmt.Sprint(len(call.args)*8)))
+		return append(output, g.takeReturns(call.dest, 1)...)
+	}
 
-		output += g.push("rax")
+	output = append(output, g.load("rax", call.callee)...)
+	output = append(output, asmInstr("cmp", "QWORD [rax - 16]", fmt.Sprint(len(call.args))))
+	output = append(output, asmInstr("jne", badCall))
+	output = append(output, asmInstr("mov", "rcx", "QWORD [rax - 8]"))
+	output = append(output, asmInstr("shl", "rcx", "3"))
+	output = append(output, asmInstr("sub", "rsp", "rcx"))
+	// rax has the callee so the arguments go through rcx
+	for i := len(call.args) - 1; i >= 0; i-- {
+		code, operand := g.operand(call.args[i], "rcx")
+		if !isRegister(operand) {
+			operand = "QWORD " + operand
+		}
+		output = append(output, code...)
+		output = append(output, asmInstr("push", operand))
+	}
+	output = append(output, asmInstr("call", "rax"))
+	// between instructions the stack is always the frame
+	return append(output, asmInstr("lea", "rsp", fmt.Sprintf("[rbp - %d]", g.allocation.frameSize)))
+}
 
-	default:
-		panic(fmt.Errorf("generator error: don't know how to generate term: %T", rawTerm))
+// takes the return values of a call off the stack
+// keeping the first if the call is used as a value
+func (g *Generator) takeReturns(dest opt.Optional[IRTemp], returnCount int) []AsmLine {
+	output := []AsmLine{}
+	if dest.HasValue() {
+		output = append(output, asmInstr("pop", "rax"))
+		output = append(output, g.store(dest.MustGetValue(), "rax")...)
+		returnCount--
 	}
-	return output, nil
+	return append(output, asmInstr("add", "rsp", fmt.Sprint(returnCount*8)))
 }
 
-func (g *Generator) GenScope(scope NodeScope) (string, error) {
-	output := ""
+func (g *Generator) GenSyscall(syscall IRSyscall) []AsmLine {
+	output := []AsmLine{}
 
-	output += g.beginScope()
+	// the arguments go through the stack as some of the
+	// registers they're put in might be holding the others
+	argRegisters := []string{"rax", "rdi", "rsi", "rdx", "r10", "r8", "r9"}
+	for _, arg := range syscall.args {
+		output = append(output, g.push(arg)...)
+	}
+	for i := len(syscall.args) - 1; i >= 0; i-- {
+		output = append(output, asmInstr("pop", argRegisters[i]))
+	}
+	output = append(output, asmInstr("syscall"))
 
-	for _, stmt := range scope.stmts {
-		generated, err := g.GenStmt(stmt)
-		if err != nil {
-			return "", err
-		}
-		output += generated + "\n"
+	if !syscall.dest.HasValue() {
+		return output
 	}
 
-	output += g.endScope()
+	// the kernel returns -4095 to -1 for errors
+	g.reserveGlobal("molten_errno")
+	okLabel := g.ir.createLabel("syscallOk")
+	output = append(output, asmInstr("cmp", "rax", "-4095"))
+	output = append(output, asmInstr("jb", okLabel))
+	output = append(output, asmInstr("neg", "rax"))
+	output = append(output, asmInstr("mov", "[rel molten_errno]", "rax"))
+	output = append(output, asmInstr("mov", "rax", "-1"))
+	output = append(output, AsmLabel{name: okLabel})
+	output = append(output, g.store(syscall.dest.MustGetValue(), "rax")...)
 
-	return output, nil
+	return output
 }
 
-func (g *Generator) GenFunctionBody(body NodeScope, params []Variable) (string, error) {
-	output := ""
+// reads the hidden variadic arguments of the current function.
+// `vaCount(args)` gives how many were passed and `vaArg(args, i)` gives the ith one
+func (g *Generator) GenVariadicBuiltin(rawInstr IRInstr) []AsmLine {
+	output := []AsmLine{}
 
-	// start "scope" for the function body
-	g.scopes = append(g.scopes, len(g.variables))
+	switch instr := rawInstr.(type) {
+	case IRVaCount:
+		output = append(output, asmInstr("mov", "rax", "[rbp + 16]"))
+		output = append(output, g.store(instr.dest, "rax")...)
 
-	g.currentFunction.scopeIndex = len(g.scopes) - 1
+	case IRVaArg:
+		output = append(output, g.load("rax", instr.index)...)
 
-	g.variables = append(g.variables, params...)
+		outOfRange := g.runtimeErrorLabel("badVarArg", "variadic argument index out of range")
+		output = append(output, asmInstr("cmp", "rax", "[rbp + 16]"))
+		output = append(output, asmInstr("jae", outOfRange))
 
-	if g.genASMComments {
-		output += "\t;=====FUNCTION BODY=====\n"
+		function := g.function.function
+		firstVarArg := (function.firstParamLoc() + function.parameters) * 8
+		output = append(output, asmInstr("mov", "rax", fmt.Sprintf("[rbp + rax*8 + %d]", firstVarArg)))
+		output = append(output, g.store(instr.dest, "rax")...)
+
+	default:
+		panic(fmt.Errorf("generator error: not a variadic builtin: %T", rawInstr))
 	}
-	for _, stmt := range body.stmts {
-		generated, err := g.GenStmt(stmt)
-		if err != nil {
-			return "", err
+	return output
+}
+
+// registers an error that is reported while the program is running
+// and returns the label to jump to for reporting it
+func (g *Generator) runtimeErrorLabel(name string, message string) string {
+	label := "molten_" + name
+	for _, e := range g.runtimeErrors {
+		if e.label == label {
+			return label
 		}
-		output += generated + "\n"
 	}
+	g.runtimeErrors = append(g.runtimeErrors, RuntimeError{label: label, message: message})
+	return label
+}
 
-	output += g.exitFunction(true)
+// prints the error message to stderr and exits with code 1
+func (g *Generator) GenRuntimeError(runtimeErr RuntimeError) string {
+	output := runtimeErr.label + ":\n"
+	output += "\tmov rax, 1\n"
+	output += "\tmov rdi, 2\n"
+	output += "\tlea rsi, [rel " + runtimeErr.label + "Msg]\n"
+	output += fmt.Sprintf("\tmov rdx, %d\n", len(runtimeErr.message)+1)
+	output += "\tsyscall\n"
+	output += "\tmov rax, 60\n"
+	output += "\tmov rdi, 1\n"
+	output += "\tsyscall\n"
+	output += fmt.Sprintf("%sMsg: db \"%s\", 10\n", runtimeErr.label, runtimeErr.message)
 
-	return output, nil
+	return output
 }
 
-func (g *Generator) GenIf(_if NodeStmtIf) (string, error) {
-	output := ""
-
-	expr, err := g.GenExpr(_if.expr)
-	if err != nil {
-		return "", err
+func (g *Generator) GenAsm(asm IRAsm) ([]AsmLine, error) {
+	output := g.comment("---start_asm---")
+	for _, reg := range asm.clobbers {
+		output = append(output, asmInstr("push", reg))
 	}
-	output += expr
-
-	output += g.pop("rax")
 
-	label := g.createLabel("else")
-	output += "\ttest rax, rax\n"
-	output += "\tjz " + label + "\n"
-	scope, err := g.GenScope(_if.scope)
+	// operands are always in memory so they're given a size for
+	// instructions that don't otherwise have one, E.G. `add %x, 1`
+	lines, err := expandAsmBody(asm.body, func(name string, _ LineInfo) (string, error) {
+		return "QWORD " + g.location(localVar(asm.operands[name])), nil
+	})
 	if err != nil {
-		return "", err
+		return nil, err
+	}
+	for _, line := range lines {
+		output = append(output, AsmRaw{text: line})
 	}
-	output += scope
-	output += label + ":\n"
 
-	if _if.elseBranch.HasValue() {
-		_else, err := g.GenElse(_if.elseBranch.MustGetValue())
-		if err != nil {
-			return "", err
-		}
-		output += _else
+	for i := len(asm.clobbers) - 1; i >= 0; i-- {
+		output = append(output, asmInstr("pop", asm.clobbers[i]))
 	}
+	output = append(output, g.comment("---end_asm---")...)
 
 	return output, nil
 }
 
-func (g *Generator) GenElse(rawElse NodeElse) (string, error) {
-	output := ""
+var asmSizes = []string{"BYTE", "WORD", "DWORD", "QWORD"}
 
-	switch _else := rawElse.(type) {
-	case NodeElseScope:
-		scope, err := g.GenScope(_else.scope)
-		if err != nil {
-			return "", err
-		}
-		output += scope
-	case NodeElseElif:
-		ifStmt, err := g.GenIf(_else.ifStmt)
-		if err != nil {
-			return "", err
+// splits the body of an asm statement into its non empty lines with each
+// `%name` placeholder replaced by what operand gives for that name
+func expandAsmBody(body Token, operand func(name string, lineInfo LineInfo) (string, error)) ([]string, error) {
+	lines := []string{}
+
+	text := []rune(body.value.MustGetValue())
+	lineInfo := body.lineInfo
+	line := ""
+	for i := 0; i < len(text); i++ {
+		c := text[i]
+
+		if c == '%' && i+1 < len(text) && text[i+1] == '%' {
+			line += "%"
+			i++
+			lineInfo.IncWord(text[i-1 : i+1])
+			continue
 		}
-		output += ifStmt
-	default:
-		panic(fmt.Errorf("generator error: don't know how to generate else branch: %T", rawElse))
-	}
-	return output, nil
 
-}
+		if c == '%' {
+			end := i + 1
+			for end < len(text) && (unicode.IsLetter(text[end]) || unicode.IsDigit(text[end]) || text[end] == '$' || text[end] == '_') {
+				end++
+			}
 
-func (g *Generator) push(reg string) string {
-	g.stackSize++
-	return "\tpush " + reg + "\n"
-}
-func (g *Generator) pop(reg string) string {
-	g.stackSize--
-	return "\tpop " + reg + "\n"
-}
+			replacement, err := operand(string(text[i+1:end]), lineInfo)
+			if err != nil {
+				return nil, err
+			}
+			// a size written before the placeholder is used instead of the operand's
+			if words := strings.Fields(line); len(words) > 0 && slices.Contains(asmSizes, strings.ToUpper(words[len(words)-1])) {
+				replacement = strings.TrimPrefix(replacement, "QWORD ")
+			}
+			line += replacement
 
-func (g *Generator) beginScope() string {
-	output := ""
+			lineInfo.IncWord(text[i:end])
+			i = end - 1
+			continue
+		}
 
-	g.scopes = append(g.scopes, len(g.variables))
+		if c == '\n' {
+			if trimmed := strings.TrimSpace(line); trimmed != "" {
+				lines = append(lines, trimmed)
+			}
+			line = ""
+			lineInfo.NextLine()
+			continue
+		}
 
-	if g.genASMComments {
-		output += "\t;---start_scope---\n"
+		line += string(c)
+		lineInfo.IncColumn()
+	}
+	if trimmed := strings.TrimSpace(line); trimmed != "" {
+		lines = append(lines, trimmed)
 	}
 
-	return output
+	return lines, nil
 }
-func (g *Generator) endScope() string {
-	output := ""
-
-	popCount := len(g.variables) - g.scopes[len(g.scopes)-1]
-
-	g.stackSize -= uint(popCount)
-	g.variables = g.variables[0 : len(g.variables)-popCount]
-	g.scopes = g.scopes[0 : len(g.scopes)-1]
 
-	output += "\tadd rsp, " + fmt.Sprintf("%d", popCount*8) + "\n"
-
-	if g.genASMComments {
-		output += "\t;---end_scope---\n"
+// the register or memory operand a temp or local is in
+func (g *Generator) location(v irVar) string {
+	if reg, ok := g.allocation.registers[v]; ok {
+		return reg
+	}
+	if v.local != nil && v.local.isParameter {
+		return g.paramOperand(v.local)
 	}
+	slot, ok := g.allocation.slots[v]
+	if !ok {
+		panic(fmt.Errorf("generator error: %s has no register or slot", g.function.varName(v)))
+	}
+	return fmt.Sprintf("[rbp - %d]", (slot+1)*8)
+}
 
-	return output
+// where a parameter was passed
+func (g *Generator) paramOperand(param *IRLocal) string {
+	return fmt.Sprintf("[rbp + %d]", (param.index+g.function.function.firstParamLoc())*8)
 }
-func (g *Generator) exitFunction(updateLocal bool) string {
-	targetVariableCount := g.scopes[g.currentFunction.scopeIndex] + g.currentFunction.parameters
-	popCount := len(g.variables) - targetVariableCount
 
-	// localPopCount := len(g.variables) - g.scopes[len(g.scopes)-1]
+// where a callee saved register is kept while the function uses it
+func (g *Generator) savedOperand(reg string) string {
+	return fmt.Sprintf("[rbp - %d]", (g.allocation.savedSlots[reg]+1)*8)
+}
 
-	// for i := len(g.scopes) - 1; i >= g.currentFunction.scopeIndex; i-- {
-	// 	len(g.variables)- g.scopes[]
-	// }
-	// popCount := localPopCount - paramCount
+func isRegister(operand string) bool {
+	return !strings.HasPrefix(operand, "[")
+}
 
-	if updateLocal {
-		localPopCount := popCount + g.currentFunction.parameters
+func fitsInt32(value IRConst) bool {
+	return int64(int32(value)) == int64(value)
+}
 
-		g.stackSize -= uint(localPopCount)
-		g.variables = g.variables[0 : len(g.variables)-localPopCount]
-		g.scopes = g.scopes[0:g.currentFunction.scopeIndex]
+// puts a value in a register or memory operand
+func (g *Generator) load(dest string, value IRValue) []AsmLine {
+	switch v := value.(type) {
+	case IRConst:
+		if isRegister(dest) {
+			return []AsmLine{asmInstr("mov", dest, fmt.Sprint(int64(v)))}
+		}
+		// only 32 bit constants can be moved straight to memory
+		if fitsInt32(v) {
+			return []AsmLine{asmInstr("mov", "QWORD "+dest, fmt.Sprint(int64(v)))}
+		}
+		return append([]AsmLine{asmInstr("mov", "rax", fmt.Sprint(int64(v)))}, g.move(dest, "rax")...)
+	case IRTemp:
+		return g.move(dest, g.location(tempVar(v)))
+	default:
+		panic(fmt.Errorf("generator error: don't know how to load value: %T", value))
 	}
+}
 
-	return "\tadd rsp, " + fmt.Sprintf("%d", popCount*8) + "\n"
+func (g *Generator) store(temp IRTemp, reg string) []AsmLine {
+	return g.move(g.location(tempVar(temp)), reg)
 }
 
-func (g *Generator) createLabel(labelCtx ...string) string {
-	var suffix string
-	if len(labelCtx) == 1 {
-		suffix = "_" + labelCtx[0]
-	} else {
-		suffix = ""
+// moves between registers and memory, going through rax if both are memory
+func (g *Generator) move(dest string, src string) []AsmLine {
+	if dest == src {
+		return []AsmLine{}
 	}
-
-	g.labelCount++
-	return fmt.Sprintf("label%d%s", g.labelCount, suffix)
+	if !isRegister(dest) && !isRegister(src) {
+		return []AsmLine{asmInstr("mov", "rax", src), asmInstr("mov", dest, "rax")}
+	}
+	return []AsmLine{asmInstr("mov", dest, src)}
 }
 
-type Variable struct {
-	name     string
-	stackLoc uint
-
-	isParameter bool
+// an operand for the value that can be used as the source of an
+// instruction, loading it into scratch if it has to be
+func (g *Generator) operand(value IRValue, scratch string) ([]AsmLine, string) {
+	switch v := value.(type) {
+	case IRConst:
+		if fitsInt32(v) {
+			return []AsmLine{}, fmt.Sprint(int64(v))
+		}
+		return g.load(scratch, v), scratch
+	case IRTemp:
+		return []AsmLine{}, g.location(tempVar(v))
+	default:
+		panic(fmt.Errorf("generator error: don't know how to use value: %T", value))
+	}
 }
 
-type Function struct {
-	name        string
-	returnCount int
-	parameters  int
+// push only takes 32 bit constants
+func (g *Generator) push(value IRValue) []AsmLine {
+	code, operand := g.operand(value, "rax")
+	if !isRegister(operand) {
+		operand = "QWORD " + operand
+	}
+	return append(code, asmInstr("push", operand))
+}
 
-	scopeIndex int
+type RuntimeError struct {
+	label   string
+	message string
 }
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"slices"
+	"strings"
+	"testing"
+
+	opt "github.com/moltenwolfcub/moltenCompiler/optional"
+)
+
+func TestExpandAsmBody(t *testing.T) {
+	operand := func(name string, lineInfo LineInfo) (string, error) {
+		return "QWORD [rbp - 8]", nil
+	}
+
+	tests := []struct {
+		name string
+		body string
+		want []string
+	}{
+		{"placeholder", "mov rax, %x", []string{"mov rax, QWORD [rbp - 8]"}},
+		{"immediate", "add %acc, 1", []string{"add QWORD [rbp - 8], 1"}},
+		{"own size", "mov DWORD %x, 1", []string{"mov DWORD [rbp - 8], 1"}},
+		{"lowercase size", "mov qword %x, 1", []string{"mov qword [rbp - 8], 1"}},
+		{"literal percent", "mov rax, 10 %% 3", []string{"mov rax, 10 % 3"}},
+		{"lines", "\n  push 1\n\n  pop rax\n", []string{"push 1", "pop rax"}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			body := Token{tokenType: asmBody, value: opt.ToOptional(test.body), lineInfo: NewLineInfo("main.mltn")}
+			got, err := expandAsmBody(body, operand)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !slices.Equal(got, test.want) {
+				t.Errorf("got %q, want %q", got, test.want)
+			}
+		})
+	}
+}
+
+func TestIndirectCallReturns(t *testing.T) {
+	tests := []struct {
+		name   string
+		source string
+		// what the call checks the function's return count with
+		want string
+	}{
+		{"value", "func 1 f() { return 1; }\nvar g = f;\nvar x = g();\nsyscall(60, x);", "cmp QWORD [rax - 8], 1"},
+		// the function's returns are thrown away whatever their number
+		{"statement", "func 1 f() { return 1; }\nvar g = f;\ng();", "mov rcx, QWORD [rax - 8]"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ir := lowerSource(t, test.source)
+			generator := NewGenerator(ir)
+			asm, err := generator.GenProg()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !strings.Contains(asm, test.want) {
+				t.Errorf("no %q in\n%s", test.want, asm)
+			}
+			if strings.Contains(asm, "cmp QWORD [rax - 8], 0") {
+				t.Error("a call checks for a function with no returns")
+			}
+		})
+	}
+}
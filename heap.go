@@ -0,0 +1,125 @@
+package main
+
+import "fmt"
+
+/*
+	The heap is a list of blocks after the program break, grown with brk.
+
+	Each block has a 16 byte header of its size and a magic number saying
+	whether it is in use, then the memory given out by alloc followed by an
+	8 byte guard. Freed blocks are kept in a list, linked through their first
+	qword, and reused by later allocations that fit in them.
+
+	In debug mode the guard is filled in by alloc and checked by free so
+	writes past the end of a block are caught when the block is freed, as
+	are double frees and frees of pointers alloc didn't return.
+*/
+
+const (
+	heapAllocatedMagic = 0x4D4F4C54
+	heapFreeMagic      = 0x46524545
+	heapGuard          = 0x7E7E7E7E
+)
+
+// `alloc(n)` gives a pointer to at least n bytes of memory and `free(p)` gives it back
+func (g *Generator) GenRuntimeCall(call IRRuntimeCall) []AsmLine {
+	g.usesHeap = true
+	g.reserveGlobal("molten_heapEnd")
+	g.reserveGlobal("molten_freeList")
+
+	output := g.load("rdi", call.arg)
+	output = append(output, asmInstr("call", call.label))
+	if call.dest.HasValue() {
+		output = append(output, g.store(call.dest.MustGetValue(), "rax")...)
+	}
+
+	return output
+}
+
+func (g *Generator) GenHeapRuntime() string {
+	outOfMemory := g.runtimeErrorLabel("outOfMemory", "out of memory")
+
+	output := ""
+
+	// rdi is the number of bytes wanted, rounded up to a whole number of qwords
+	output += "molten_alloc:\n"
+	output += "\tadd rdi, 7\n"
+	output += "\tand rdi, -8\n"
+	output += "\tjnz molten_allocSearchStart\n"
+	output += "\tmov rdi, 8\n"
+	output += "molten_allocSearchStart:\n"
+
+	// first fit search of the free list. rcx is the link to the current block
+	output += "\tlea rcx, [rel molten_freeList]\n"
+	output += "molten_allocSearch:\n"
+	output += "\tmov rax, [rcx]\n"
+	output += "\ttest rax, rax\n"
+	output += "\tjz molten_allocGrow\n"
+	output += "\tcmp [rax - 16], rdi\n"
+	output += "\tjae molten_allocFound\n"
+	output += "\tmov rcx, rax\n"
+	output += "\tjmp molten_allocSearch\n"
+	output += "molten_allocFound:\n"
+	output += "\tmov rdx, [rax]\n"
+	output += "\tmov [rcx], rdx\n"
+	output += "\tjmp molten_allocMark\n"
+
+	// nothing free was big enough so move the program break up for a new block
+	output += "molten_allocGrow:\n"
+	output += "\tmov rsi, rdi\n"
+	output += "\tmov rax, [rel molten_heapEnd]\n"
+	output += "\ttest rax, rax\n"
+	output += "\tjnz molten_allocHaveEnd\n"
+	output += "\tpush rsi\n"
+	output += "\tmov rax, 12\n"
+	output += "\tmov rdi, 0\n"
+	output += "\tsyscall\n"
+	output += "\tpop rsi\n"
+	output += "molten_allocHaveEnd:\n"
+	output += "\tmov rdx, rax\n"
+	output += "\tlea rdi, [rax + rsi + 24]\n"
+	output += "\tpush rdx\n"
+	output += "\tpush rsi\n"
+	output += "\tpush rdi\n"
+	output += "\tmov rax, 12\n"
+	output += "\tsyscall\n"
+	output += "\tpop rdi\n"
+	output += "\tpop rsi\n"
+	output += "\tpop rdx\n"
+	output += "\tcmp rax, rdi\n"
+	output += "\tjb " + outOfMemory + "\n"
+	output += "\tmov [rel molten_heapEnd], rdi\n"
+	output += "\tmov [rdx], rsi\n"
+	output += "\tlea rax, [rdx + 16]\n"
+
+	output += "molten_allocMark:\n"
+	output += fmt.Sprintf("\tmov QWORD [rax - 8], 0x%X\n", heapAllocatedMagic)
+	if g.debugHeap {
+		output += "\tmov rdx, [rax - 16]\n"
+		output += fmt.Sprintf("\tmov QWORD [rax + rdx], 0x%X\n", heapGuard)
+	}
+	output += "\tret\n\n"
+
+	// rdi is the pointer being freed. freeing 0 does nothing
+	output += "molten_free:\n"
+	output += "\ttest rdi, rdi\n"
+	output += "\tjz molten_freeDone\n"
+	if g.debugHeap {
+		badFree := g.runtimeErrorLabel("badFree", "double free or free of a pointer not from alloc")
+		overflow := g.runtimeErrorLabel("heapOverflow", "write past the end of a heap block")
+
+		output += fmt.Sprintf("\tcmp QWORD [rdi - 8], 0x%X\n", heapAllocatedMagic)
+		output += "\tjne " + badFree + "\n"
+		output += "\tmov rdx, [rdi - 16]\n"
+		output += fmt.Sprintf("\tcmp QWORD [rdi + rdx], 0x%X\n", heapGuard)
+		output += "\tjne " + overflow + "\n"
+	}
+	output += fmt.Sprintf("\tmov QWORD [rdi - 8], 0x%X\n", heapFreeMagic)
+	output += "\tmov rax, [rel molten_freeList]\n"
+	output += "\tmov [rdi], rax\n"
+	output += "\tmov [rel molten_freeList], rdi\n"
+	output += "molten_freeDone:\n"
+	output += "\tret\n"
+
+	return output
+}
@@ -0,0 +1,408 @@
+package main
+
+import (
+	"fmt"
+	"maps"
+)
+
+/*
+	`var x;` sets x to 0 so reading it before it's assigned isn't an error
+	but it's usually a mistake. The initialisation check follows every path
+	through the code keeping track of which variables have definitely been
+	assigned and which might have been, and warns when a variable is read
+	on a path where it might not have been.
+
+	Taking a variable's address might initialise it through the pointer and
+	inline assembly might write to any variable it uses so both count.
+	Parameters are always initialised. `-Werror=uninitialised` is strict
+	mode, making every possibly uninitialised read an error.
+*/
+
+type initState struct {
+	// assigned on every path to here
+	definitely map[*Variable]bool
+	// assigned on at least one path to here
+	maybe map[*Variable]bool
+
+	// no path reaches here so nothing read here is reported
+	unreachable bool
+}
+
+func newInitState() initState {
+	return initState{definitely: map[*Variable]bool{}, maybe: map[*Variable]bool{}}
+}
+
+func unreachableInitState() initState {
+	state := newInitState()
+	state.unreachable = true
+	return state
+}
+
+func (s initState) clone() initState {
+	return initState{definitely: maps.Clone(s.definitely), maybe: maps.Clone(s.maybe), unreachable: s.unreachable}
+}
+
+func (s *initState) assign(v *Variable) {
+	s.definitely[v] = true
+	s.maybe[v] = true
+}
+
+// the state where two paths join. an unreachable path adds nothing
+func (s initState) join(other initState) initState {
+	if s.unreachable {
+		return other.clone()
+	}
+	if other.unreachable {
+		return s.clone()
+	}
+
+	joined := newInitState()
+	for v := range s.definitely {
+		if other.definitely[v] {
+			joined.definitely[v] = true
+		}
+	}
+	for v := range s.maybe {
+		joined.maybe[v] = true
+	}
+	for v := range other.maybe {
+		joined.maybe[v] = true
+	}
+	return joined
+}
+
+type initChecker struct {
+	linter *Linter
+
+	// the states at each break out of the loops being checked, innermost last
+	breaks [][]initState
+
+	// each variable is only reported once
+	reported map[*Variable]bool
+}
+
+func (l *Linter) checkInitialisation() {
+	c := initChecker{linter: l, reported: map[*Variable]bool{}}
+
+	state := c.checkStmts(l.program.init, newInitState())
+	c.checkStmts(l.program.stmts, state)
+}
+
+func (c *initChecker) checkFunction(stmt NodeStmtFunctionDefinition) {
+	state := newInitState()
+	for _, p := range stmt.parameters {
+		state.assign(p)
+	}
+
+	outerBreaks := c.breaks
+	c.breaks = [][]initState{}
+	c.checkStmts(stmt.body.stmts, state)
+	c.breaks = outerBreaks
+}
+
+func (c *initChecker) checkStmts(stmts []NodeStmt, state initState) initState {
+	for _, stmt := range stmts {
+		state = c.checkStmt(stmt, state)
+	}
+	return state
+}
+
+func (c *initChecker) checkStmt(rawStmt NodeStmt, state initState) initState {
+	switch stmt := rawStmt.(type) {
+	case NodeStmtVarDeclare:
+		if stmt.expr.HasValue() {
+			state = c.checkExpr(stmt.expr.MustGetValue(), state)
+			state.assign(stmt.variable)
+		} else {
+			// declaring a variable again in a loop sets it back to 0
+			delete(state.definitely, stmt.variable)
+			delete(state.maybe, stmt.variable)
+		}
+		return state
+
+	case NodeStmtVarAssign:
+		state = c.checkExpr(stmt.expr, state)
+		state.assign(stmt.variable)
+		return state
+
+	case NodeStmtPointerAssign:
+		c.read(stmt.variable, stmt.ident, state)
+		return c.checkExpr(stmt.expr, state)
+
+	case NodeScope:
+		return c.checkStmts(stmt.stmts, state)
+
+	case NodeStmtIf:
+		return c.checkIf(stmt, state)
+
+	case NodeStmtWhile:
+		return c.checkWhile(stmt, state)
+
+	case NodeStmtBreak:
+		c.breaks[len(c.breaks)-1] = append(c.breaks[len(c.breaks)-1], state)
+		return unreachableInitState()
+
+	case NodeStmtContinue:
+		return unreachableInitState()
+
+	case NodeStmtFunctionDefinition:
+		c.checkFunction(stmt)
+		return state
+
+	case NodeStmtImport:
+		return state
+
+	case NodeFunctionCall:
+		return c.checkExpr(stmt, state)
+
+	case NodeIndirectCall:
+		return c.checkExpr(stmt, state)
+
+	case NodeStmtReturn:
+		c.checkExprs(stmt.returns, state)
+		return unreachableInitState()
+
+	case NodeStmtSyscall:
+		state = c.checkExprs(stmt.arguments, state)
+		if !stmtFallsThrough(stmt) {
+			return unreachableInitState()
+		}
+		return state
+
+	case NodeStmtAsm:
+		for _, v := range stmt.variables {
+			state.assign(v)
+		}
+		return state
+
+	default:
+		panic(fmt.Errorf("initialisation error: don't know how to check statement: %T", rawStmt))
+	}
+}
+
+func (c *initChecker) checkIf(stmt NodeStmtIf, state initState) initState {
+	state = c.checkExpr(stmt.expr, state)
+
+	then := c.checkStmts(stmt.scope.stmts, state.clone())
+	if !stmt.elseBranch.HasValue() {
+		return then.join(state)
+	}
+
+	switch _else := stmt.elseBranch.MustGetValue().(type) {
+	case NodeElseScope:
+		return then.join(c.checkStmts(_else.scope.stmts, state))
+	case NodeElseElif:
+		return then.join(c.checkIf(_else.ifStmt, state))
+	default:
+		panic(fmt.Errorf("initialisation error: don't know how to check else branch: %T", _else))
+	}
+}
+
+// the condition and body of a loop run after the body has run before
+// so anything assigned in the body might have been assigned in them
+func (c *initChecker) checkWhile(stmt NodeStmtWhile, state initState) initState {
+	for v := range assignedIn(stmt.scope.stmts) {
+		state.maybe[v] = true
+	}
+	state = c.checkExpr(stmt.expr, state)
+
+	c.breaks = append(c.breaks, []initState{})
+	c.checkStmts(stmt.scope.stmts, state.clone())
+	breaks := c.breaks[len(c.breaks)-1]
+	c.breaks = c.breaks[:len(c.breaks)-1]
+
+	// an infinite loop is only left by breaking out of it
+	after := state
+	if value, constant := constantValue(stmt.expr); constant && value != 0 {
+		after = unreachableInitState()
+	}
+	for _, b := range breaks {
+		after = after.join(b)
+	}
+	return after
+}
+
+func (c *initChecker) checkExprs(exprs []NodeExpr, state initState) initState {
+	for _, e := range exprs {
+		state = c.checkExpr(e, state)
+	}
+	return state
+}
+
+func (c *initChecker) checkExpr(rawExpr NodeExpr, state initState) initState {
+	switch expr := rawExpr.(type) {
+	case NodeBinExprAdd:
+		return c.checkExprs([]NodeExpr{expr.left, expr.right}, state)
+	case NodeBinExprSubtract:
+		return c.checkExprs([]NodeExpr{expr.left, expr.right}, state)
+	case NodeBinExprMultiply:
+		return c.checkExprs([]NodeExpr{expr.left, expr.right}, state)
+	case NodeBinExprDivide:
+		return c.checkExprs([]NodeExpr{expr.left, expr.right}, state)
+	case NodeBinExprModulo:
+		return c.checkExprs([]NodeExpr{expr.left, expr.right}, state)
+
+	case NodeTermIntLiteral:
+		return state
+
+	case NodeTermIdentifier:
+		if v, ok := expr.symbol.(*Variable); ok {
+			c.read(v, expr.identifier, state)
+		}
+		return state
+
+	case NodeFunctionCall:
+		if v, ok := expr.symbol.(*Variable); ok {
+			c.read(v, expr.ident, state)
+		}
+
+		// the variadic builtins name the variadic
+		// parameter which isn't a variable
+		params := expr.params
+		if builtin, ok := expr.symbol.(Builtin); ok && (builtin.name == "vaCount" || builtin.name == "vaArg") {
+			params = params[1:]
+		}
+		return c.checkExprs(params, state)
+
+	case NodeIndirectCall:
+		state = c.checkExpr(expr.callee, state)
+		return c.checkExprs(expr.params, state)
+
+	case NodeTermRoundBracketExpr:
+		return c.checkExpr(expr.expr, state)
+
+	case NodeTermSyscall:
+		return c.checkExprs(expr.arguments, state)
+
+	case NodeTermPointer:
+		if v, ok := expr.symbol.(*Variable); ok {
+			state.assign(v)
+		}
+		return state
+
+	case NodeTermPointerDereference:
+		c.read(expr.variable, expr.identifier, state)
+		return state
+
+	default:
+		panic(fmt.Errorf("initialisation error: don't know how to check expression: %T", rawExpr))
+	}
+}
+
+func (c *initChecker) read(v *Variable, ident Token, state initState) {
+	if state.unreachable || state.definitely[v] || c.reported[v] {
+		return
+	}
+	c.reported[v] = true
+
+	message := fmt.Sprintf("variable '%s' is read before it's assigned", v.name)
+	if state.maybe[v] {
+		message = fmt.Sprintf("variable '%s' might be read before it's assigned", v.name)
+	}
+	c.linter.warn(ident.PositionedError(codeUninitialised, message).
+		WithLabel(v.ident.span(), "declared here without a value").
+		WithNote("variables declared without a value start as 0"))
+}
+
+// every variable that might be assigned by a list of statements
+func assignedIn(stmts []NodeStmt) map[*Variable]bool {
+	assigned := map[*Variable]bool{}
+
+	var inExpr func(rawExpr NodeExpr)
+	inExpr = func(rawExpr NodeExpr) {
+		switch expr := rawExpr.(type) {
+		case NodeBinExprAdd:
+			inExpr(expr.left)
+			inExpr(expr.right)
+		case NodeBinExprSubtract:
+			inExpr(expr.left)
+			inExpr(expr.right)
+		case NodeBinExprMultiply:
+			inExpr(expr.left)
+			inExpr(expr.right)
+		case NodeBinExprDivide:
+			inExpr(expr.left)
+			inExpr(expr.right)
+		case NodeBinExprModulo:
+			inExpr(expr.left)
+			inExpr(expr.right)
+		case NodeFunctionCall:
+			for _, p := range expr.params {
+				inExpr(p)
+			}
+		case NodeIndirectCall:
+			inExpr(expr.callee)
+			for _, p := range expr.params {
+				inExpr(p)
+			}
+		case NodeTermRoundBracketExpr:
+			inExpr(expr.expr)
+		case NodeTermSyscall:
+			for _, a := range expr.arguments {
+				inExpr(a)
+			}
+		case NodeTermPointer:
+			if v, ok := expr.symbol.(*Variable); ok {
+				assigned[v] = true
+			}
+		}
+	}
+
+	var inStmts func(stmts []NodeStmt)
+	var inIf func(stmt NodeStmtIf)
+	inIf = func(stmt NodeStmtIf) {
+		inExpr(stmt.expr)
+		inStmts(stmt.scope.stmts)
+		if !stmt.elseBranch.HasValue() {
+			return
+		}
+		switch _else := stmt.elseBranch.MustGetValue().(type) {
+		case NodeElseScope:
+			inStmts(_else.scope.stmts)
+		case NodeElseElif:
+			inIf(_else.ifStmt)
+		}
+	}
+	inStmts = func(stmts []NodeStmt) {
+		for _, rawStmt := range stmts {
+			switch stmt := rawStmt.(type) {
+			case NodeStmtVarDeclare:
+				if stmt.expr.HasValue() {
+					inExpr(stmt.expr.MustGetValue())
+					assigned[stmt.variable] = true
+				}
+			case NodeStmtVarAssign:
+				inExpr(stmt.expr)
+				assigned[stmt.variable] = true
+			case NodeStmtPointerAssign:
+				inExpr(stmt.expr)
+			case NodeScope:
+				inStmts(stmt.stmts)
+			case NodeStmtIf:
+				inIf(stmt)
+			case NodeStmtWhile:
+				inExpr(stmt.expr)
+				inStmts(stmt.scope.stmts)
+			case NodeFunctionCall:
+				inExpr(stmt)
+			case NodeIndirectCall:
+				inExpr(stmt)
+			case NodeStmtReturn:
+				for _, r := range stmt.returns {
+					inExpr(r)
+				}
+			case NodeStmtSyscall:
+				for _, a := range stmt.arguments {
+					inExpr(a)
+				}
+			case NodeStmtAsm:
+				for _, v := range stmt.variables {
+					assigned[v] = true
+				}
+			}
+		}
+	}
+
+	inStmts(stmts)
+	return assigned
+}
@@ -0,0 +1,327 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	opt "github.com/moltenwolfcub/moltenCompiler/optional"
+)
+
+/*
+	Inlining copies the body of a function into the places it's called
+	so they don't pay for the call. Functions marked `inline` always are
+	and when optimising so are ones small enough that the copy isn't much
+	bigger than the call.
+
+	The copied function's parameters and variables become variables of
+	the caller and its temps are given new numbers. The arguments are
+	stored into the parameters before going to the copy of its first
+	block and returning stores the value to a variable the call's value
+	is loaded from after it.
+
+	Functions that can call themselves, even through others, are never
+	inlined as there'd be no end to it. Variadic functions aren't either
+	as their arguments are found from where the call put them, and nor
+	are functions with inline assembly as copying it would copy any
+	labels it defines.
+*/
+
+// the most instructions a function can have to be inlined without being marked
+const inlineSizeLimit = 8
+
+type inliner struct {
+	program *IRProgram
+
+	irFunctions map[*Function]*IRFunction
+	// whether small functions are inlined without being marked
+	automatic bool
+
+	// functions whose calls have already been inlined into them
+	expanded map[*IRFunction]bool
+	// functions that can call themselves
+	recursive map[*Function]bool
+}
+
+// whether anything was inlined
+func inlineFunctions(program *IRProgram, automatic bool) (bool, error) {
+	in := inliner{
+		program:     program,
+		irFunctions: map[*Function]*IRFunction{},
+		automatic:   automatic,
+		expanded:    map[*IRFunction]bool{},
+		recursive:   map[*Function]bool{},
+	}
+	for _, f := range program.functions {
+		in.irFunctions[f.function] = f
+	}
+	in.findRecursion()
+
+	errors := ErrorList{}
+	for _, f := range program.functions {
+		if !f.function.inline {
+			continue
+		}
+		if in.recursive[f.function] {
+			errors.Add(f.function.ident.PositionedError(codeRecursiveInline, fmt.Sprintf("function '%s' can't be inlined as it can call itself", f.function.name)))
+		}
+		if f.function.variadic {
+			errors.Add(f.function.ident.PositionedError(codeVariadicInline, fmt.Sprintf("function '%s' can't be inlined as it's variadic", f.function.name)))
+		}
+		if hasAsm(f) {
+			errors.Add(f.function.ident.PositionedError(codeAsmInline, fmt.Sprintf("function '%s' can't be inlined as it has inline assembly", f.function.name)))
+		}
+	}
+	if len(errors) > 0 {
+		return false, errors.Err()
+	}
+
+	inlined := false
+	for _, f := range append(program.functions, program.start) {
+		if in.expand(f) {
+			inlined = true
+		}
+	}
+	return inlined, nil
+}
+
+// the functions each one calls directly
+func (in *inliner) callees(f *IRFunction) []*Function {
+	callees := []*Function{}
+	for _, b := range f.blocks {
+		for _, rawInstr := range b.instrs {
+			if call, ok := rawInstr.(IRCall); ok {
+				callees = append(callees, call.function)
+			}
+		}
+	}
+	return callees
+}
+
+// calls through function values can't be inlined so only direct calls count
+func (in *inliner) findRecursion() {
+	for _, f := range in.program.functions {
+		visited := map[*Function]bool{}
+		toVisit := in.callees(f)
+
+		for len(toVisit) > 0 {
+			callee := toVisit[len(toVisit)-1]
+			toVisit = toVisit[:len(toVisit)-1]
+
+			if callee == f.function {
+				in.recursive[f.function] = true
+				break
+			}
+			if !visited[callee] {
+				visited[callee] = true
+				toVisit = append(toVisit, in.callees(in.irFunctions[callee])...)
+			}
+		}
+	}
+}
+
+func (in *inliner) shouldInline(function *Function) bool {
+	if in.recursive[function] || function.variadic || hasAsm(in.irFunctions[function]) {
+		return false
+	}
+	if function.inline {
+		return true
+	}
+	return in.automatic && irFunctionSize(in.irFunctions[function]) <= inlineSizeLimit
+}
+
+func hasAsm(f *IRFunction) bool {
+	for _, b := range f.blocks {
+		for _, instr := range b.instrs {
+			if _, ok := instr.(IRAsm); ok {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// the number of instructions and terminators in a function
+func irFunctionSize(f *IRFunction) int {
+	size := 0
+	for _, b := range f.blocks {
+		size += len(b.instrs) + 1
+	}
+	return size
+}
+
+// inlines the calls in a function, after inlining the calls in the
+// functions it inlines so their size is what would be copied.
+// gives whether anything was inlined
+func (in *inliner) expand(f *IRFunction) bool {
+	if in.expanded[f] {
+		return false
+	}
+	in.expanded[f] = true
+
+	inlined := false
+	for i := 0; i < len(f.blocks); i++ {
+		b := f.blocks[i]
+		for j, rawInstr := range b.instrs {
+			call, ok := rawInstr.(IRCall)
+			if !ok || !in.shouldInline(call.function) {
+				continue
+			}
+
+			callee := in.irFunctions[call.function]
+			in.expand(callee)
+			copied := in.inlineCall(f, i, j, call, callee)
+			inlined = true
+
+			// carry on after the call, the copy's calls are already inlined
+			i += copied
+			break
+		}
+	}
+
+	f.linkBlocks()
+	return inlined
+}
+
+// replaces the call at instruction j of block i with a copy of the callee.
+// gives the number of blocks of the copy, which go straight after block i
+// and are followed by a block with the rest of block i
+func (in *inliner) inlineCall(f *IRFunction, i int, j int, call IRCall, callee *IRFunction) int {
+	b := f.blocks[i]
+
+	locals := map[*IRLocal]*IRLocal{}
+	for _, local := range append(callee.params, callee.locals...) {
+		copied := &IRLocal{name: local.name, index: len(f.locals)}
+		f.locals = append(f.locals, copied)
+		locals[local] = copied
+	}
+
+	var result opt.Optional[*IRLocal]
+	if call.dest.HasValue() {
+		local := &IRLocal{name: "result", index: len(f.locals)}
+		f.locals = append(f.locals, local)
+		result = opt.ToOptional(local)
+	}
+
+	tempBase := f.tempCount
+	f.tempCount += callee.tempCount
+	renamer := irRenamer{
+		temp:  func(t IRTemp) IRTemp { return IRTemp(tempBase) + t },
+		local: func(l *IRLocal) *IRLocal { return locals[l] },
+	}
+
+	after := &IRBlock{label: in.program.createLabel("afterInline"), instrs: []IRInstr{}, terminator: b.terminator}
+	if result.HasValue() {
+		after.instrs = append(after.instrs, IRLoad{dest: call.dest.MustGetValue(), local: result.MustGetValue()})
+	}
+	after.instrs = append(after.instrs, b.instrs[j+1:]...)
+
+	blocks := map[*IRBlock]*IRBlock{}
+	copies := []*IRBlock{}
+	for _, calleeBlock := range callee.blocks {
+		_, labelCtx, _ := strings.Cut(calleeBlock.label, "_")
+		copied := &IRBlock{label: in.program.createLabel(labelCtx), instrs: []IRInstr{}}
+		blocks[calleeBlock] = copied
+		copies = append(copies, copied)
+	}
+	for k, calleeBlock := range callee.blocks {
+		copied := copies[k]
+		for _, instr := range calleeBlock.instrs {
+			copied.instrs = append(copied.instrs, renamer.instr(instr))
+		}
+
+		switch t := calleeBlock.terminator.(type) {
+		case IRJump:
+			copied.terminator = IRJump{target: blocks[t.target]}
+		case IRBranch:
+			copied.terminator = IRBranch{condition: renamer.value(t.condition), then: blocks[t.then], otherwise: blocks[t.otherwise]}
+		case IRReturn:
+			// the return lowering adds to the end of a function that
+			// exits with a syscall has no value but can't be reached
+			if result.HasValue() && len(t.values) > 0 {
+				copied.instrs = append(copied.instrs, IRStore{local: result.MustGetValue(), value: renamer.value(t.values[0])})
+			}
+			copied.terminator = IRJump{target: after}
+		default:
+			panic(fmt.Errorf("inlining error: don't know how to inline terminator: %T", calleeBlock.terminator))
+		}
+	}
+
+	b.instrs = b.instrs[:j]
+	for k, p := range callee.params {
+		b.instrs = append(b.instrs, IRStore{local: locals[p], value: call.args[k]})
+	}
+	b.terminator = IRJump{target: copies[0]}
+
+	rest := append(append(copies, after), f.blocks[i+1:]...)
+	f.blocks = append(f.blocks[:i+1], rest...)
+	return len(copies)
+}
+
+// gives the temps and locals of a copied function their new ones
+type irRenamer struct {
+	temp  func(IRTemp) IRTemp
+	local func(*IRLocal) *IRLocal
+}
+
+func (r irRenamer) value(value IRValue) IRValue {
+	if temp, ok := value.(IRTemp); ok {
+		return r.temp(temp)
+	}
+	return value
+}
+
+func (r irRenamer) dest(dest opt.Optional[IRTemp]) opt.Optional[IRTemp] {
+	if !dest.HasValue() {
+		return dest
+	}
+	return opt.ToOptional(r.temp(dest.MustGetValue()))
+}
+
+func (r irRenamer) instr(rawInstr IRInstr) IRInstr {
+	switch instr := replaceInstrUses(rawInstr, r.value).(type) {
+	case IRCopy:
+		instr.dest = r.temp(instr.dest)
+		return instr
+	case IRBinary:
+		instr.dest = r.temp(instr.dest)
+		return instr
+	case IRLoad:
+		instr.dest = r.temp(instr.dest)
+		instr.local = r.local(instr.local)
+		return instr
+	case IRStore:
+		instr.local = r.local(instr.local)
+		return instr
+	case IRLoadPointer:
+		instr.dest = r.temp(instr.dest)
+		return instr
+	case IRStorePointer:
+		return instr
+	case IRAddressOf:
+		instr.dest = r.temp(instr.dest)
+		instr.local = r.local(instr.local)
+		return instr
+	case IRFunctionAddress:
+		instr.dest = r.temp(instr.dest)
+		return instr
+	case IRLoadGlobal:
+		instr.dest = r.temp(instr.dest)
+		return instr
+	case IRCall:
+		instr.dest = r.dest(instr.dest)
+		return instr
+	case IRCallIndirect:
+		instr.dest = r.dest(instr.dest)
+		return instr
+	case IRSyscall:
+		instr.dest = r.dest(instr.dest)
+		return instr
+	case IRRuntimeCall:
+		instr.dest = r.dest(instr.dest)
+		return instr
+	default:
+		// vaCount and vaArg are only in variadic functions and
+		// asm is only in functions with it, neither of which are inlined
+		panic(fmt.Errorf("inlining error: don't know how to inline instruction: %T", rawInstr))
+	}
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestInlineFunctions(t *testing.T) {
+	tests := []struct {
+		name      string
+		source    string
+		automatic bool
+		// the functions still called from the top level code
+		want []string
+	}{
+		{"marked", "inline func 1 f(x) { return x + 1; }\nsyscall(60, f(1));", false, []string{}},
+		{"small", "func 1 f(x) { return x + 1; }\nsyscall(60, f(1));", true, []string{}},
+		{"small without optimising", "func 1 f(x) { return x + 1; }\nsyscall(60, f(1));", false, []string{"f"}},
+		{"recursive", "func 1 f(x) { if (x) { return f(x - 1); } return 0; }\nsyscall(60, f(1));", true, []string{"f"}},
+		{"mutually recursive", "func 1 f(x) { return g(x); }\nfunc 1 g(x) { return f(x); }\nsyscall(60, f(1));", true, []string{"f"}},
+		{"variadic", "func 1 f(...xs) { return vaCount(xs); }\nsyscall(60, f(1, 2));", true, []string{"f"}},
+		{"asm", "func 1 f(x) { asm { add %x, 1 } return x; }\nsyscall(60, f(1));", true, []string{"f"}},
+		{"nested", "func 1 g(x) { return x * 2; }\ninline func 1 f(x) { return g(x) + 1; }\nsyscall(60, f(1));", true, []string{}},
+		{"no return value", "inline func 0 die(code) { syscall(60, code); }\ndie(3);", false, []string{}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ir := lowerSource(t, test.source)
+			if _, err := inlineFunctions(&ir, test.automatic); err != nil {
+				t.Fatal(err)
+			}
+
+			got := []string{}
+			for _, call := range findInstrs[IRCall](ir.start) {
+				got = append(got, call.function.name)
+			}
+			if !slices.Equal(got, test.want) {
+				t.Errorf("got calls to %v, want %v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestInlineRefused(t *testing.T) {
+	tests := []struct {
+		name   string
+		source string
+		want   []string
+	}{
+		{"recursive", "inline func 1 f(x) { if (x) { return f(x - 1); } return 0; }\nf(1);", []string{codeRecursiveInline}},
+		{"through another", "inline func 1 f(x) { return g(x); }\nfunc 1 g(x) { return f(x); }\nf(1);", []string{codeRecursiveInline}},
+		{"variadic", "inline func 1 f(...xs) { return vaCount(xs); }\nf(1);", []string{codeVariadicInline}},
+		{"asm", "inline func 0 f() { asm { nop } }\nf();", []string{codeAsmInline}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ir := lowerSource(t, test.source)
+			_, err := inlineFunctions(&ir, true)
+			if got := errorCodes(err); !slices.Equal(got, test.want) {
+				t.Errorf("got %v, want %v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestInlinedValue(t *testing.T) {
+	// the inlined code gives the same value once it's folded
+	ir := lowerSource(t, "inline func 1 f(a, b) { if (a) { return a * b; } return b; }\nsyscall(60, f(3, 4) + f(0, 2));")
+	if _, err := inlineFunctions(&ir, false); err != nil {
+		t.Fatal(err)
+	}
+	if err := foldConstants(&ir); err != nil {
+		t.Fatal(err)
+	}
+
+	syscalls := findInstrs[IRSyscall](ir.start)
+	if got := syscalls[len(syscalls)-1].args[1]; got != IRConst(14) {
+		t.Errorf("got %v, want 14", got)
+	}
+}
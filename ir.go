@@ -0,0 +1,581 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	opt "github.com/moltenwolfcub/moltenCompiler/optional"
+)
+
+/*
+	The IR sits between the AST and the assembly so the program can be
+	changed and optimised without working on either.
+
+	Each function is a list of basic blocks. A block is straight line code
+	ending in a terminator that jumps, branches, returns or exits, and the
+	blocks each one can go to next are linked as its successors. Code works
+	on temporaries, each assigned by exactly one instruction, and constants.
+	A molten variable is a slot in its function's frame which is only used
+	through loads and stores as its address can be taken.
+
+	The textual form, written with `-emit-ir`, looks like
+
+	func add_2(a, b) returns 1 {
+	label1_entry:
+		%0 = load a
+		%1 = load b
+		%2 = add %0, %1
+		ret %2
+	}
+*/
+
+type IRProgram struct {
+	// in the order they're defined
+	functions []*IRFunction
+
+	// the top level code, which is where the program starts
+	start *IRFunction
+
+	labelCount int
+}
+
+// block labels are unique across the whole program as they're assembly labels
+func (p *IRProgram) createLabel(labelCtx string) string {
+	p.labelCount++
+	return fmt.Sprintf("label%d_%s", p.labelCount, labelCtx)
+}
+
+type IRFunction struct {
+	label string
+
+	// nil for the top level code
+	function *Function
+
+	params []*IRLocal
+	locals []*IRLocal
+
+	// the first block is where the function starts
+	blocks []*IRBlock
+
+	tempCount int
+}
+
+func (f *IRFunction) newTemp() IRTemp {
+	f.tempCount++
+	return IRTemp(f.tempCount - 1)
+}
+
+// a variable's slot in the frame
+type IRLocal struct {
+	name        string
+	isParameter bool
+
+	// its position in the function's params or locals
+	index int
+}
+
+type IRBlock struct {
+	label      string
+	instrs     []IRInstr
+	terminator IRTerminator
+
+	// set by linkBlocks
+	predecessors []*IRBlock
+	successors   []*IRBlock
+}
+
+// sets every block's successors from its terminator and predecessors from those
+func (f *IRFunction) linkBlocks() {
+	for _, b := range f.blocks {
+		b.predecessors = []*IRBlock{}
+		b.successors = []*IRBlock{}
+	}
+	for _, b := range f.blocks {
+		switch t := b.terminator.(type) {
+		case IRJump:
+			b.successors = append(b.successors, t.target)
+		case IRBranch:
+			b.successors = append(b.successors, t.then, t.otherwise)
+		case IRReturn, IRExit:
+		default:
+			panic(fmt.Errorf("ir error: unknown terminator: %T", b.terminator))
+		}
+		for _, s := range b.successors {
+			s.predecessors = append(s.predecessors, b)
+		}
+	}
+}
+
+type IRValue interface {
+	IsIRValue()
+}
+
+// the result of an instruction
+type IRTemp int
+
+func (IRTemp) IsIRValue() {}
+
+type IRConst int64
+
+func (IRConst) IsIRValue() {}
+
+type IRBinaryOp int
+
+const (
+	irAdd IRBinaryOp = iota
+	irSubtract
+	irMultiply
+	// division is unsigned
+	irDivide
+	irModulo
+)
+
+var irBinaryOpNames = map[IRBinaryOp]string{
+	irAdd:      "add",
+	irSubtract: "sub",
+	irMultiply: "mul",
+	irDivide:   "div",
+	irModulo:   "mod",
+}
+
+type IRInstr interface {
+	IsIRInstr()
+}
+
+type IRCopy struct {
+	dest IRTemp
+	src  IRValue
+}
+
+type IRBinary struct {
+	op    IRBinaryOp
+	dest  IRTemp
+	left  IRValue
+	right IRValue
+
+	// the start of the right operand for errors about dividing by it
+	position Token
+}
+
+type IRLoad struct {
+	dest  IRTemp
+	local *IRLocal
+}
+
+type IRStore struct {
+	local *IRLocal
+	value IRValue
+}
+
+type IRLoadPointer struct {
+	dest    IRTemp
+	address IRValue
+}
+
+type IRStorePointer struct {
+	address IRValue
+	value   IRValue
+}
+
+type IRAddressOf struct {
+	dest  IRTemp
+	local *IRLocal
+}
+
+type IRFunctionAddress struct {
+	dest     IRTemp
+	function *Function
+}
+
+// a qword in the bss section, E.G. molten_errno
+type IRLoadGlobal struct {
+	dest   IRTemp
+	global string
+}
+
+// calls with a destination are used as values so return exactly one
+type IRCall struct {
+	dest     opt.Optional[IRTemp]
+	function *Function
+	args     []IRValue
+}
+
+// a call through a function value. it's a runtime error
+// if the function doesn't take the arguments, or doesn't
+// give one value when the call is used as a value
+type IRCallIndirect struct {
+	dest   opt.Optional[IRTemp]
+	callee IRValue
+	args   []IRValue
+}
+
+// the first argument is the syscall number. syscalls used as
+// values set errno and give -1 if the kernel returned an error
+type IRSyscall struct {
+	dest opt.Optional[IRTemp]
+	args []IRValue
+}
+
+type IRVaCount struct {
+	dest IRTemp
+}
+
+type IRVaArg struct {
+	dest  IRTemp
+	index IRValue
+}
+
+// calls a routine of the runtime, E.G. molten_alloc, with one argument in rdi
+type IRRuntimeCall struct {
+	dest  opt.Optional[IRTemp]
+	label string
+	arg   IRValue
+}
+
+// inline assembly with each `%name` placeholder
+// referring to the local of that name
+type IRAsm struct {
+	clobbers []string
+	body     Token
+	operands map[string]*IRLocal
+}
+
+func (IRCopy) IsIRInstr()            {}
+func (IRBinary) IsIRInstr()          {}
+func (IRLoad) IsIRInstr()            {}
+func (IRStore) IsIRInstr()           {}
+func (IRLoadPointer) IsIRInstr()     {}
+func (IRStorePointer) IsIRInstr()    {}
+func (IRAddressOf) IsIRInstr()       {}
+func (IRFunctionAddress) IsIRInstr() {}
+func (IRLoadGlobal) IsIRInstr()      {}
+func (IRCall) IsIRInstr()            {}
+func (IRCallIndirect) IsIRInstr()    {}
+func (IRSyscall) IsIRInstr()         {}
+func (IRVaCount) IsIRInstr()         {}
+func (IRVaArg) IsIRInstr()           {}
+func (IRRuntimeCall) IsIRInstr()     {}
+func (IRAsm) IsIRInstr()             {}
+
+type IRTerminator interface {
+	IsIRTerminator()
+}
+
+type IRJump struct {
+	target *IRBlock
+}
+
+// goes to then if the condition isn't 0
+type IRBranch struct {
+	condition IRValue
+	then      *IRBlock
+	otherwise *IRBlock
+}
+
+// returns from a function putting the values in its return slots
+type IRReturn struct {
+	values []IRValue
+}
+
+// ends the program with an exit code
+type IRExit struct {
+	code IRValue
+}
+
+func (IRJump) IsIRTerminator()   {}
+func (IRBranch) IsIRTerminator() {}
+func (IRReturn) IsIRTerminator() {}
+func (IRExit) IsIRTerminator()   {}
+
+// the values an instruction reads
+func instrUses(rawInstr IRInstr) []IRValue {
+	switch instr := rawInstr.(type) {
+	case IRCopy:
+		return []IRValue{instr.src}
+	case IRBinary:
+		return []IRValue{instr.left, instr.right}
+	case IRStore:
+		return []IRValue{instr.value}
+	case IRLoadPointer:
+		return []IRValue{instr.address}
+	case IRStorePointer:
+		return []IRValue{instr.address, instr.value}
+	case IRCall:
+		return instr.args
+	case IRCallIndirect:
+		return append(slices.Clone(instr.args), instr.callee)
+	case IRSyscall:
+		return instr.args
+	case IRVaArg:
+		return []IRValue{instr.index}
+	case IRRuntimeCall:
+		return []IRValue{instr.arg}
+	case IRLoad, IRAddressOf, IRFunctionAddress, IRLoadGlobal, IRVaCount, IRAsm:
+		return []IRValue{}
+	default:
+		panic(fmt.Errorf("ir error: unknown instruction: %T", rawInstr))
+	}
+}
+
+// the instruction with each value it reads replaced
+func replaceInstrUses(rawInstr IRInstr, replace func(IRValue) IRValue) IRInstr {
+	replaceAll := func(values []IRValue) []IRValue {
+		replaced := []IRValue{}
+		for _, v := range values {
+			replaced = append(replaced, replace(v))
+		}
+		return replaced
+	}
+
+	switch instr := rawInstr.(type) {
+	case IRCopy:
+		instr.src = replace(instr.src)
+		return instr
+	case IRBinary:
+		instr.left = replace(instr.left)
+		instr.right = replace(instr.right)
+		return instr
+	case IRStore:
+		instr.value = replace(instr.value)
+		return instr
+	case IRLoadPointer:
+		instr.address = replace(instr.address)
+		return instr
+	case IRStorePointer:
+		instr.address = replace(instr.address)
+		instr.value = replace(instr.value)
+		return instr
+	case IRCall:
+		instr.args = replaceAll(instr.args)
+		return instr
+	case IRCallIndirect:
+		instr.args = replaceAll(instr.args)
+		instr.callee = replace(instr.callee)
+		return instr
+	case IRSyscall:
+		instr.args = replaceAll(instr.args)
+		return instr
+	case IRVaArg:
+		instr.index = replace(instr.index)
+		return instr
+	case IRRuntimeCall:
+		instr.arg = replace(instr.arg)
+		return instr
+	case IRLoad, IRAddressOf, IRFunctionAddress, IRLoadGlobal, IRVaCount, IRAsm:
+		return instr
+	default:
+		panic(fmt.Errorf("ir error: unknown instruction: %T", rawInstr))
+	}
+}
+
+// the temp an instruction assigns, if it has one
+func instrDest(rawInstr IRInstr) opt.Optional[IRTemp] {
+	switch instr := rawInstr.(type) {
+	case IRCopy:
+		return opt.ToOptional(instr.dest)
+	case IRBinary:
+		return opt.ToOptional(instr.dest)
+	case IRLoad:
+		return opt.ToOptional(instr.dest)
+	case IRLoadPointer:
+		return opt.ToOptional(instr.dest)
+	case IRAddressOf:
+		return opt.ToOptional(instr.dest)
+	case IRFunctionAddress:
+		return opt.ToOptional(instr.dest)
+	case IRLoadGlobal:
+		return opt.ToOptional(instr.dest)
+	case IRVaCount:
+		return opt.ToOptional(instr.dest)
+	case IRVaArg:
+		return opt.ToOptional(instr.dest)
+	case IRCall:
+		return instr.dest
+	case IRCallIndirect:
+		return instr.dest
+	case IRSyscall:
+		return instr.dest
+	case IRRuntimeCall:
+		return instr.dest
+	case IRStore, IRStorePointer, IRAsm:
+		return opt.Optional[IRTemp]{}
+	default:
+		panic(fmt.Errorf("ir error: unknown instruction: %T", rawInstr))
+	}
+}
+
+// the values a terminator reads
+func terminatorUses(rawTerminator IRTerminator) []IRValue {
+	switch t := rawTerminator.(type) {
+	case IRJump:
+		return []IRValue{}
+	case IRBranch:
+		return []IRValue{t.condition}
+	case IRReturn:
+		return t.values
+	case IRExit:
+		return []IRValue{t.code}
+	default:
+		panic(fmt.Errorf("ir error: unknown terminator: %T", rawTerminator))
+	}
+}
+
+func replaceTerminatorUses(rawTerminator IRTerminator, replace func(IRValue) IRValue) IRTerminator {
+	switch t := rawTerminator.(type) {
+	case IRJump:
+		return t
+	case IRBranch:
+		t.condition = replace(t.condition)
+		return t
+	case IRReturn:
+		values := []IRValue{}
+		for _, v := range t.values {
+			values = append(values, replace(v))
+		}
+		t.values = values
+		return t
+	case IRExit:
+		t.code = replace(t.code)
+		return t
+	default:
+		panic(fmt.Errorf("ir error: unknown terminator: %T", rawTerminator))
+	}
+}
+
+func (p IRProgram) String() string {
+	functions := []string{}
+	for _, f := range append(p.functions, p.start) {
+		functions = append(functions, f.String())
+	}
+	return strings.Join(functions, "\n\n") + "\n"
+}
+
+func (f IRFunction) String() string {
+	params := []string{}
+	for _, p := range f.params {
+		params = append(params, f.localName(p))
+	}
+
+	output := fmt.Sprintf("func %s(%s)", f.label, strings.Join(params, ", "))
+	if f.function != nil {
+		if f.function.variadic {
+			output += " variadic"
+		}
+		output += fmt.Sprintf(" returns %d", f.function.returnCount)
+	}
+	output += " {\n"
+
+	for _, b := range f.blocks {
+		output += b.label + ":\n"
+		for _, instr := range b.instrs {
+			output += "\t" + f.instrString(instr) + "\n"
+		}
+		output += "\t" + f.terminatorString(b.terminator) + "\n"
+	}
+
+	return output + "}"
+}
+
+// locals are shown by name unless another local
+// in the function has the same name
+func (f IRFunction) localName(local *IRLocal) string {
+	for _, other := range append(f.params, f.locals...) {
+		if other != local && other.name == local.name {
+			if local.isParameter {
+				return fmt.Sprintf("%s.p%d", local.name, local.index)
+			}
+			return fmt.Sprintf("%s.%d", local.name, local.index)
+		}
+	}
+	return local.name
+}
+
+func irValueString(value IRValue) string {
+	switch v := value.(type) {
+	case IRTemp:
+		return fmt.Sprintf("%%%d", int(v))
+	case IRConst:
+		return fmt.Sprint(int64(v))
+	default:
+		panic(fmt.Errorf("ir error: unknown value: %T", value))
+	}
+}
+
+func irValuesString(values []IRValue) string {
+	strs := []string{}
+	for _, v := range values {
+		strs = append(strs, irValueString(v))
+	}
+	return strings.Join(strs, ", ")
+}
+
+// E.G. "%3 = " or nothing if there isn't a destination
+func irDestString(dest opt.Optional[IRTemp]) string {
+	if !dest.HasValue() {
+		return ""
+	}
+	return irValueString(dest.MustGetValue()) + " = "
+}
+
+func (f IRFunction) instrString(rawInstr IRInstr) string {
+	switch instr := rawInstr.(type) {
+	case IRCopy:
+		return fmt.Sprintf("%s = %s", irValueString(instr.dest), irValueString(instr.src))
+	case IRBinary:
+		return fmt.Sprintf("%s = %s %s, %s", irValueString(instr.dest), irBinaryOpNames[instr.op], irValueString(instr.left), irValueString(instr.right))
+	case IRLoad:
+		return fmt.Sprintf("%s = load %s", irValueString(instr.dest), f.localName(instr.local))
+	case IRStore:
+		return fmt.Sprintf("store %s, %s", f.localName(instr.local), irValueString(instr.value))
+	case IRLoadPointer:
+		return fmt.Sprintf("%s = load [%s]", irValueString(instr.dest), irValueString(instr.address))
+	case IRStorePointer:
+		return fmt.Sprintf("store [%s], %s", irValueString(instr.address), irValueString(instr.value))
+	case IRAddressOf:
+		return fmt.Sprintf("%s = addr %s", irValueString(instr.dest), f.localName(instr.local))
+	case IRFunctionAddress:
+		return fmt.Sprintf("%s = addr @%s", irValueString(instr.dest), instr.function.label())
+	case IRLoadGlobal:
+		return fmt.Sprintf("%s = load @%s", irValueString(instr.dest), instr.global)
+	case IRCall:
+		return fmt.Sprintf("%scall @%s(%s)", irDestString(instr.dest), instr.function.label(), irValuesString(instr.args))
+	case IRCallIndirect:
+		return fmt.Sprintf("%scall %s(%s)", irDestString(instr.dest), irValueString(instr.callee), irValuesString(instr.args))
+	case IRSyscall:
+		return fmt.Sprintf("%ssyscall %s", irDestString(instr.dest), irValuesString(instr.args))
+	case IRVaCount:
+		return fmt.Sprintf("%s = vacount", irValueString(instr.dest))
+	case IRVaArg:
+		return fmt.Sprintf("%s = vaarg %s", irValueString(instr.dest), irValueString(instr.index))
+	case IRRuntimeCall:
+		return fmt.Sprintf("%scall @%s(%s)", irDestString(instr.dest), instr.label, irValueString(instr.arg))
+	case IRAsm:
+		operands := []string{}
+		for name, local := range instr.operands {
+			operands = append(operands, fmt.Sprintf("%s=%s", name, f.localName(local)))
+		}
+		slices.Sort(operands)
+		return fmt.Sprintf("asm (%s) [%s] %q", strings.Join(instr.clobbers, ", "), strings.Join(operands, ", "), strings.TrimSpace(instr.body.value.MustGetValue()))
+	default:
+		panic(fmt.Errorf("ir error: unknown instruction: %T", rawInstr))
+	}
+}
+
+func (f IRFunction) terminatorString(rawTerminator IRTerminator) string {
+	switch t := rawTerminator.(type) {
+	case IRJump:
+		return "jmp " + t.target.label
+	case IRBranch:
+		return fmt.Sprintf("br %s, %s, %s", irValueString(t.condition), t.then.label, t.otherwise.label)
+	case IRReturn:
+		if len(t.values) == 0 {
+			return "ret"
+		}
+		return "ret " + irValuesString(t.values)
+	case IRExit:
+		return "exit " + irValueString(t.code)
+	default:
+		panic(fmt.Errorf("ir error: unknown terminator: %T", rawTerminator))
+	}
+}
@@ -1,7 +1,7 @@
 package main
 
-import "fmt"
-
+// positions are 1 based. columns count code points
+// so a tab or a multibyte character is a single column
 type LineInfo struct {
 	File string
 	Line int
@@ -29,6 +29,7 @@ func (l *LineInfo) IncWord(word []rune) {
 	l.Col += len(word)
 }
 
-func (l LineInfo) PositionedError(message string) error {
-	return fmt.Errorf("%s:%d:%d: %s", l.File, l.Line, l.Col, message)
+// an error pointing at a single column
+func (l LineInfo) PositionedError(code string, message string) Diagnostic {
+	return Diagnostic{code: code, message: message, span: Span{start: l, length: 1}}
 }
@@ -0,0 +1,260 @@
+package main
+
+import (
+	"embed"
+	"errors"
+	"fmt"
+	"io/fs"
+	"path/filepath"
+	"slices"
+	"strings"
+	"unicode"
+
+	opt "github.com/moltenwolfcub/moltenCompiler/optional"
+)
+
+// the standard library is bundled into the compiler and
+// every file outside of it can use its functions without importing them
+//
+//go:embed std/*.mltn
+var stdFiles embed.FS
+
+const stdDir = "std"
+
+// the standard library's files are named and imported with this in
+// front. it's never where a file on disk is looked for so a user's
+// own std directory can't be mistaken for it
+const stdScheme = "<std>/"
+
+func isStdFile(name string) bool {
+	return strings.HasPrefix(name, stdScheme)
+}
+
+type SourceFile struct {
+	name string
+
+	// prefix given to the labels of this file's functions so
+	// functions with the same name in different files don't clash
+	namespace string
+	imports   []string
+
+	prog NodeProg
+}
+
+// Loader reads, tokenises and parses a file and everything it imports
+// then merges them into a single program.
+type Loader struct {
+	rootDir string
+
+	// whether the standard library is imported into every file
+	prelude bool
+
+	files map[string]SourceFile
+	order []string
+
+	// the text of every file read so errors can show it
+	sources map[string]string
+
+	loading    []string
+	namespaces map[string]bool
+
+	errors ErrorList
+}
+
+func NewLoader(rootDir string) Loader {
+	return Loader{
+		rootDir:    rootDir,
+		prelude:    true,
+		files:      map[string]SourceFile{},
+		sources:    map[string]string{},
+		order:      []string{},
+		loading:    []string{},
+		namespaces: map[string]bool{},
+	}
+}
+
+func (l *Loader) LoadProg(mainFile string) (NodeProg, error) {
+	mainFile = filepath.Clean(mainFile)
+	if isStdFile(mainFile) {
+		return NodeProg{}, errors.New("cannot read the file")
+	}
+
+	// the main file wasn't imported by anything
+	err := l.loadFile(mainFile, NodeStmtImport{})
+	if err != nil {
+		return NodeProg{}, err
+	}
+	// the files with errors can't be merged into a program
+	// but every file was still checked so they're all reported
+	if err := l.errors.Err(); err != nil {
+		return NodeProg{}, err
+	}
+
+	prog := NodeProg{
+		stmts:    []NodeStmt{},
+		files:    l.files,
+		mainFile: mainFile,
+	}
+
+	// files are ordered so dependencies come before the files importing them.
+	// that way functions are always generated before they are called
+	for _, name := range l.order {
+		file := l.files[name]
+
+		initStmts := []NodeStmt{}
+		for _, stmt := range file.prog.stmts {
+			switch stmt.(type) {
+			case NodeStmtImport:
+			case NodeStmtFunctionDefinition:
+				prog.stmts = append(prog.stmts, stmt)
+			default:
+				if name == mainFile {
+					prog.stmts = append(prog.stmts, stmt)
+				} else {
+					initStmts = append(initStmts, stmt)
+				}
+			}
+		}
+
+		// top level code of imported files runs before the main file
+		// in its own scope so its variables don't leak into other files
+		if len(initStmts) > 0 {
+			prog.init = append(prog.init, NodeScope{stmts: initStmts})
+		}
+	}
+
+	return prog, nil
+}
+
+func (l *Loader) loadFile(name string, importedBy NodeStmtImport) error {
+	for i, loading := range l.loading {
+		if loading == name {
+			cycle := strings.Join(append(l.loading[i:], name), " -> ")
+			return importedBy.path.PositionedError(codeImportCycle, fmt.Sprintf("import cycle: %s", cycle))
+		}
+	}
+	if _, loaded := l.files[name]; loaded {
+		return nil
+	}
+
+	isMain := !importedBy.path.value.HasValue()
+
+	var program string
+	var err error
+	if isStdFile(name) {
+		var file []byte
+		file, err = stdFiles.ReadFile(stdDir + "/" + strings.TrimPrefix(name, stdScheme))
+		program = string(file)
+	} else {
+		program, err = loadProgram(filepath.Join(l.rootDir, name))
+	}
+	if err != nil {
+		if !isMain {
+			return importedBy.path.PositionedError(codeUnreadableImport, fmt.Sprintf("cannot read imported file: %s", name))
+		}
+		return err
+	}
+
+	l.sources[name] = program
+
+	tokeniser := NewTokeniser(program, name)
+	tokens, err := tokeniser.Tokenise()
+	l.errors.Add(err)
+
+	parser := NewParser(tokens, name)
+	prog, err := parser.ParseProg()
+	l.errors.Add(err)
+
+	file := SourceFile{
+		name:      name,
+		namespace: l.namespaceFor(name, isMain),
+		imports:   []string{},
+		prog:      prog,
+	}
+
+	imports := []NodeStmtImport{}
+	if l.prelude && !isStdFile(name) {
+		stdNames, err := fs.Glob(stdFiles, stdDir+"/*.mltn")
+		if err != nil {
+			return err
+		}
+		for _, stdName := range stdNames {
+			imports = append(imports, NodeStmtImport{path: Token{
+				tokenType: stringLiteral,
+				value:     opt.ToOptional(stdScheme + filepath.Base(stdName)),
+				lineInfo:  NewLineInfo(name),
+			}})
+		}
+	}
+	for _, stmt := range prog.stmts {
+		if importStmt, ok := stmt.(NodeStmtImport); ok {
+			imports = append(imports, importStmt)
+		}
+	}
+
+	l.loading = append(l.loading, name)
+	for _, importStmt := range imports {
+		importPath := importStmt.path.value.MustGetValue()
+
+		// imports are relative to the file importing them apart
+		// from the standard library which can be imported from anywhere.
+		// the scheme is checked before the path is cleaned so user paths
+		// like std/x.mltn or a/../<std>/x.mltn are always read from disk
+		var importName string
+		if isStdFile(importPath) {
+			importName = stdScheme + filepath.Clean(strings.TrimPrefix(importPath, stdScheme))
+		} else if isStdFile(name) {
+			// the standard library only imports itself
+			importName = stdScheme + filepath.Clean(filepath.Join(filepath.Dir(strings.TrimPrefix(name, stdScheme)), importPath))
+		} else {
+			importName = filepath.Clean(filepath.Join(filepath.Dir(name), importPath))
+			if isStdFile(importName) {
+				l.errors.Add(importStmt.path.PositionedError(codeUnreadableImport, fmt.Sprintf("cannot read imported file: %s", importName)).
+					WithNote(fmt.Sprintf("paths starting with `%s` are the standard library", stdScheme)))
+				continue
+			}
+		}
+
+		err := l.loadFile(importName, importStmt)
+		if err != nil {
+			l.errors.Add(err)
+			continue
+		}
+		if !slices.Contains(file.imports, importName) {
+			file.imports = append(file.imports, importName)
+		}
+	}
+	l.loading = l.loading[:len(l.loading)-1]
+
+	l.files[name] = file
+	l.order = append(l.order, name)
+
+	return nil
+}
+
+// the main file keeps plain labels. other files get a
+// prefix made from their name which is unique to them. it ends
+// with a '.' which identifiers can't have so a prefixed label
+// can't be the same as one from the main file
+func (l *Loader) namespaceFor(name string, isMain bool) string {
+	if isMain {
+		return ""
+	}
+
+	// labels can only use ASCII
+	prefix := []rune{}
+	for _, c := range strings.TrimSuffix(name, filepath.Ext(name)) {
+		if c < unicode.MaxASCII && (unicode.IsLetter(c) || unicode.IsDigit(c)) {
+			prefix = append(prefix, c)
+		} else {
+			prefix = append(prefix, '_')
+		}
+	}
+
+	namespace := string(prefix) + "."
+	for i := 2; l.namespaces[namespace]; i++ {
+		namespace = fmt.Sprintf("%s.%d.", string(prefix), i)
+	}
+	l.namespaces[namespace] = true
+	return namespace
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// writes the files, by name relative to a new directory, and loads main.mltn
+func loadFiles(t *testing.T, files map[string]string) (NodeProg, error) {
+	t.Helper()
+	dir := t.TempDir()
+	for name, source := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(source), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	loader := NewLoader(dir)
+	return loader.LoadProg("main.mltn")
+}
+
+func TestLoadStd(t *testing.T) {
+	prog, err := loadFiles(t, map[string]string{
+		"main.mltn":    "import \"./std/own.mltn\";\nimport \"<std>/math.mltn\";\nimport \"lib/../std/own.mltn\";",
+		"std/own.mltn": "func 0 own() {}",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a user's std directory is read from disk and only once
+	if _, ok := prog.files["std/own.mltn"]; !ok {
+		t.Errorf("std/own.mltn wasn't loaded from disk: %v", slices.Collect(maps.Keys(prog.files)))
+	}
+	if !isStdFile("<std>/math.mltn") || isStdFile("std/own.mltn") {
+		t.Error("std/own.mltn is counted as part of the standard library")
+	}
+	if _, ok := prog.files["<std>/math.mltn"]; !ok {
+		t.Error("the standard library wasn't loaded")
+	}
+}
+
+func TestLoadStdSchemeFromDisk(t *testing.T) {
+	_, err := loadFiles(t, map[string]string{
+		"main.mltn": "import \"lib/../<std>/io.mltn\";",
+	})
+	if got := errorCodes(err); !slices.Equal(got, []string{codeUnreadableImport}) {
+		t.Errorf("got %v, want %v", got, []string{codeUnreadableImport})
+	}
+}
+
+func TestNamespaces(t *testing.T) {
+	prog, err := loadFiles(t, map[string]string{
+		"main.mltn": "import \"a_b.mltn\";\nimport \"a/b.mltn\";",
+		"a_b.mltn":  "",
+		"a/b.mltn":  "",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	namespaces := []string{}
+	for name, file := range prog.files {
+		if isStdFile(name) {
+			continue
+		}
+		if name != "main.mltn" && !strings.HasSuffix(file.namespace, ".") {
+			t.Errorf("namespace %q of %s could be part of an identifier", file.namespace, name)
+		}
+		if slices.Contains(namespaces, file.namespace) {
+			t.Errorf("namespace %q is used twice", file.namespace)
+		}
+		namespaces = append(namespaces, file.namespace)
+	}
+}
@@ -0,0 +1,558 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+
+	opt "github.com/moltenwolfcub/moltenCompiler/optional"
+)
+
+/*
+	Lowering turns the resolved AST into the IR. Expressions are evaluated
+	in the same order as they always have been: left to right except for
+	the arguments of calls which are evaluated last to first, with the
+	callee of an indirect call after all of them.
+*/
+
+type Lowerer struct {
+	program NodeProg
+	ir      IRProgram
+
+	function *IRFunction
+	// where lowered instructions are added
+	block *IRBlock
+
+	locals map[*Variable]*IRLocal
+	loops  []irLoop
+}
+
+type irLoop struct {
+	breakTarget    *IRBlock
+	continueTarget *IRBlock
+}
+
+func NewLowerer(prog NodeProg) Lowerer {
+	return Lowerer{
+		program: prog,
+		ir:      IRProgram{functions: []*IRFunction{}},
+
+		locals: map[*Variable]*IRLocal{},
+		loops:  []irLoop{},
+	}
+}
+
+func (l *Lowerer) LowerProg() (IRProgram, error) {
+	for _, stmt := range l.program.stmts {
+		funcStmt, ok := stmt.(NodeStmtFunctionDefinition)
+		if !ok {
+			continue
+		}
+		function, err := l.lowerFunction(funcStmt)
+		if err != nil {
+			return IRProgram{}, err
+		}
+		l.ir.functions = append(l.ir.functions, function)
+	}
+
+	l.beginFunction("_start", nil)
+	for _, stmt := range append(l.program.init, l.program.stmts...) {
+		err := l.lowerStmt(stmt)
+		if err != nil {
+			return IRProgram{}, err
+		}
+	}
+
+	if l.program.main.HasValue() {
+		l.lowerMainCall(l.program.main.MustGetValue())
+	} else {
+		// exit 0 at the end of the program if it didn't exit already
+		l.terminate(IRExit{code: IRConst(0)})
+	}
+	l.ir.start = l.endFunction()
+
+	return l.ir, nil
+}
+
+// main is called with the process's arguments and what it returns is the exit code
+func (l *Lowerer) lowerMainCall(mainFunc *Function) {
+	args := []IRValue{}
+	for _, global := range []string{"molten_argc", "molten_argv", "molten_envp"}[:mainFunc.parameters] {
+		arg := l.function.newTemp()
+		l.emit(IRLoadGlobal{dest: arg, global: global})
+		args = append(args, arg)
+	}
+
+	if mainFunc.returnCount == 0 {
+		l.emit(IRCall{function: mainFunc, args: args})
+		l.terminate(IRExit{code: IRConst(0)})
+		return
+	}
+	code := l.function.newTemp()
+	l.emit(IRCall{dest: opt.ToOptional(code), function: mainFunc, args: args})
+	l.terminate(IRExit{code: code})
+}
+
+func (l *Lowerer) beginFunction(label string, function *Function) {
+	l.function = &IRFunction{
+		label:    label,
+		function: function,
+		params:   []*IRLocal{},
+		locals:   []*IRLocal{},
+		blocks:   []*IRBlock{},
+	}
+	l.startBlock(l.newBlock("entry"))
+}
+
+func (l *Lowerer) endFunction() *IRFunction {
+	function := l.function
+	function.linkBlocks()
+	l.function = nil
+	l.block = nil
+	return function
+}
+
+func (l *Lowerer) lowerFunction(stmt NodeStmtFunctionDefinition) (*IRFunction, error) {
+	l.beginFunction(stmt.function.label(), stmt.function)
+
+	for i, p := range stmt.parameters {
+		local := &IRLocal{name: p.name, isParameter: true, index: i}
+		l.function.params = append(l.function.params, local)
+		l.locals[p] = local
+	}
+
+	for _, s := range stmt.body.stmts {
+		err := l.lowerStmt(s)
+		if err != nil {
+			return nil, err
+		}
+	}
+	l.terminate(IRReturn{values: []IRValue{}})
+
+	return l.endFunction(), nil
+}
+
+func (l *Lowerer) newBlock(labelCtx string) *IRBlock {
+	return &IRBlock{label: l.ir.createLabel(labelCtx), instrs: []IRInstr{}}
+}
+
+// lowered code is added to the block from now on
+func (l *Lowerer) startBlock(block *IRBlock) {
+	l.function.blocks = append(l.function.blocks, block)
+	l.block = block
+}
+
+func (l *Lowerer) emit(instr IRInstr) {
+	l.block.instrs = append(l.block.instrs, instr)
+}
+
+// ends the current block. code after a terminator in the
+// same statements can't be reached but is still lowered
+// so it goes in a block nothing jumps to
+func (l *Lowerer) terminate(terminator IRTerminator) {
+	if l.block.terminator != nil {
+		return
+	}
+	l.block.terminator = terminator
+}
+
+// goes on to a new block from the current one
+func (l *Lowerer) continueIn(block *IRBlock) {
+	l.terminate(IRJump{target: block})
+	l.startBlock(block)
+}
+
+func (l *Lowerer) local(variable *Variable) *IRLocal {
+	local, ok := l.locals[variable]
+	if !ok {
+		panic(fmt.Errorf("lowering error: variable used before it was declared: %s", variable.name))
+	}
+	return local
+}
+
+func (l *Lowerer) lowerStmt(rawStmt NodeStmt) error {
+	// anything after a terminator goes in an unreachable block
+	if l.block.terminator != nil {
+		l.startBlock(l.newBlock("unreachable"))
+	}
+
+	switch stmt := rawStmt.(type) {
+	case NodeStmtVarDeclare:
+		var value IRValue = IRConst(0)
+		if stmt.expr.HasValue() {
+			var err error
+			value, err = l.lowerExpr(stmt.expr.MustGetValue())
+			if err != nil {
+				return err
+			}
+		}
+
+		local := &IRLocal{name: stmt.variable.name, index: len(l.function.locals)}
+		l.function.locals = append(l.function.locals, local)
+		l.locals[stmt.variable] = local
+		l.emit(IRStore{local: local, value: value})
+
+	case NodeStmtVarAssign:
+		value, err := l.lowerExpr(stmt.expr)
+		if err != nil {
+			return err
+		}
+		l.emit(IRStore{local: l.local(stmt.variable), value: value})
+
+	case NodeStmtPointerAssign:
+		value, err := l.lowerExpr(stmt.expr)
+		if err != nil {
+			return err
+		}
+		address := l.function.newTemp()
+		l.emit(IRLoad{dest: address, local: l.local(stmt.variable)})
+		l.emit(IRStorePointer{address: address, value: value})
+
+	case NodeScope:
+		for _, s := range stmt.stmts {
+			err := l.lowerStmt(s)
+			if err != nil {
+				return err
+			}
+		}
+
+	case NodeStmtIf:
+		return l.lowerIf(stmt)
+
+	case NodeStmtWhile:
+		start := l.newBlock("startWhile")
+		body := l.newBlock("whileBody")
+		end := l.newBlock("endWhile")
+
+		l.continueIn(start)
+		condition, err := l.lowerExpr(stmt.expr)
+		if err != nil {
+			return err
+		}
+		l.terminate(IRBranch{condition: condition, then: body, otherwise: end})
+
+		l.startBlock(body)
+		l.loops = append(l.loops, irLoop{breakTarget: end, continueTarget: start})
+		err = l.lowerStmt(stmt.scope)
+		if err != nil {
+			return err
+		}
+		l.loops = l.loops[:len(l.loops)-1]
+		l.terminate(IRJump{target: start})
+
+		l.startBlock(end)
+
+	case NodeStmtBreak:
+		l.terminate(IRJump{target: l.loops[len(l.loops)-1].breakTarget})
+
+	case NodeStmtContinue:
+		l.terminate(IRJump{target: l.loops[len(l.loops)-1].continueTarget})
+
+	case NodeStmtFunctionDefinition:
+		// functions are lowered before the top level code
+
+	case NodeFunctionCall:
+		_, err := l.lowerFuncCall(stmt, false)
+		return err
+
+	case NodeIndirectCall:
+		_, err := l.lowerIndirectCall(stmt.callee, stmt.params, false)
+		return err
+
+	case NodeStmtReturn:
+		values, err := l.lowerExprs(stmt.returns)
+		if err != nil {
+			return err
+		}
+
+		if stmt.function == nil {
+			// returning from top level code exits the program
+			var code IRValue = IRConst(0)
+			if len(values) == 1 {
+				code = values[0]
+			}
+			l.terminate(IRExit{code: code})
+			break
+		}
+		l.terminate(IRReturn{values: values})
+
+	case NodeStmtSyscall:
+		args, err := l.lowerExprs(stmt.arguments)
+		if err != nil {
+			return err
+		}
+		l.emit(IRSyscall{args: args})
+
+	case NodeStmtAsm:
+		asm := IRAsm{clobbers: []string{}, body: stmt.body, operands: map[string]*IRLocal{}}
+		for _, c := range stmt.clobbers {
+			asm.clobbers = append(asm.clobbers, c.value.MustGetValue())
+		}
+		for name, variable := range stmt.variables {
+			asm.operands[name] = l.local(variable)
+		}
+		l.emit(asm)
+
+	default:
+		panic(fmt.Errorf("lowering error: don't know how to lower statement: %T", rawStmt))
+	}
+	return nil
+}
+
+func (l *Lowerer) lowerIf(stmt NodeStmtIf) error {
+	condition, err := l.lowerExpr(stmt.expr)
+	if err != nil {
+		return err
+	}
+
+	then := l.newBlock("then")
+	end := l.newBlock("endIf")
+	otherwise := end
+	if stmt.elseBranch.HasValue() {
+		otherwise = l.newBlock("else")
+	}
+	l.terminate(IRBranch{condition: condition, then: then, otherwise: otherwise})
+
+	l.startBlock(then)
+	err = l.lowerStmt(stmt.scope)
+	if err != nil {
+		return err
+	}
+	l.terminate(IRJump{target: end})
+
+	if stmt.elseBranch.HasValue() {
+		l.startBlock(otherwise)
+		switch _else := stmt.elseBranch.MustGetValue().(type) {
+		case NodeElseScope:
+			err = l.lowerStmt(_else.scope)
+		case NodeElseElif:
+			err = l.lowerIf(_else.ifStmt)
+		default:
+			panic(fmt.Errorf("lowering error: don't know how to lower else branch: %T", _else))
+		}
+		if err != nil {
+			return err
+		}
+		l.terminate(IRJump{target: end})
+	}
+
+	l.startBlock(end)
+	return nil
+}
+
+func (l *Lowerer) lowerExprs(exprs []NodeExpr) ([]IRValue, error) {
+	values := []IRValue{}
+	for _, e := range exprs {
+		value, err := l.lowerExpr(e)
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, value)
+	}
+	return values, nil
+}
+
+// call arguments are evaluated from the last to the first
+func (l *Lowerer) lowerArgs(exprs []NodeExpr) ([]IRValue, error) {
+	values := make([]IRValue, len(exprs))
+	for i := len(exprs) - 1; i >= 0; i-- {
+		value, err := l.lowerExpr(exprs[i])
+		if err != nil {
+			return nil, err
+		}
+		values[i] = value
+	}
+	return values, nil
+}
+
+func (l *Lowerer) lowerExpr(rawExpr NodeExpr) (IRValue, error) {
+	binary := func(op IRBinaryOp, left NodeExpr, right NodeExpr) (IRValue, error) {
+		leftValue, err := l.lowerExpr(left)
+		if err != nil {
+			return nil, err
+		}
+		rightValue, err := l.lowerExpr(right)
+		if err != nil {
+			return nil, err
+		}
+		dest := l.function.newTemp()
+		l.emit(IRBinary{op: op, dest: dest, left: leftValue, right: rightValue, position: exprToken(right)})
+		return dest, nil
+	}
+
+	switch expr := rawExpr.(type) {
+	case NodeBinExprAdd:
+		return binary(irAdd, expr.left, expr.right)
+	case NodeBinExprSubtract:
+		return binary(irSubtract, expr.left, expr.right)
+	case NodeBinExprMultiply:
+		return binary(irMultiply, expr.left, expr.right)
+	case NodeBinExprDivide:
+		return binary(irDivide, expr.left, expr.right)
+	case NodeBinExprModulo:
+		return binary(irModulo, expr.left, expr.right)
+	case NodeTerm:
+		return l.lowerTerm(expr)
+	default:
+		panic(fmt.Errorf("lowering error: don't know how to lower expression: %T", rawExpr))
+	}
+}
+
+func (l *Lowerer) lowerTerm(rawTerm NodeTerm) (IRValue, error) {
+	switch term := rawTerm.(type) {
+	case NodeTermIntLiteral:
+		// literals too big for a signed qword are the unsigned value's bits
+		value, err := strconv.ParseUint(term.intLiteral.value.MustGetValue(), 10, 64)
+		if err != nil {
+			return nil, term.intLiteral.PositionedError(codeIntTooBig, fmt.Sprintf("int literal doesn't fit in 64 bits: %s", term.intLiteral.value.MustGetValue()))
+		}
+		return IRConst(int64(value)), nil
+
+	case NodeTermIdentifier:
+		switch symbol := term.symbol.(type) {
+		case *Variable:
+			dest := l.function.newTemp()
+			l.emit(IRLoad{dest: dest, local: l.local(symbol)})
+			return dest, nil
+		case SyscallInfo:
+			return IRConst(symbol.number), nil
+		case *Function:
+			dest := l.function.newTemp()
+			l.emit(IRFunctionAddress{dest: dest, function: symbol})
+			return dest, nil
+		default:
+			panic(fmt.Errorf("lowering error: identifier bound to unexpected symbol: %T", term.symbol))
+		}
+
+	case NodeFunctionCall:
+		value, err := l.lowerFuncCall(term, true)
+		if err != nil {
+			return nil, err
+		}
+		return value.MustGetValue(), nil
+
+	case NodeIndirectCall:
+		value, err := l.lowerIndirectCall(term.callee, term.params, true)
+		if err != nil {
+			return nil, err
+		}
+		return value.MustGetValue(), nil
+
+	case NodeTermSyscall:
+		args, err := l.lowerExprs(term.arguments)
+		if err != nil {
+			return nil, err
+		}
+		dest := l.function.newTemp()
+		l.emit(IRSyscall{dest: opt.ToOptional(dest), args: args})
+		return dest, nil
+
+	case NodeTermRoundBracketExpr:
+		return l.lowerExpr(term.expr)
+
+	case NodeTermPointer:
+		dest := l.function.newTemp()
+		if function, isFunction := term.symbol.(*Function); isFunction {
+			l.emit(IRFunctionAddress{dest: dest, function: function})
+		} else {
+			l.emit(IRAddressOf{dest: dest, local: l.local(term.symbol.(*Variable))})
+		}
+		return dest, nil
+
+	case NodeTermPointerDereference:
+		address := l.function.newTemp()
+		l.emit(IRLoad{dest: address, local: l.local(term.variable)})
+		dest := l.function.newTemp()
+		l.emit(IRLoadPointer{dest: dest, address: address})
+		return dest, nil
+
+	default:
+		panic(fmt.Errorf("lowering error: don't know how to lower term: %T", rawTerm))
+	}
+}
+
+// gives the value of the call if it's used as one
+func (l *Lowerer) lowerFuncCall(call NodeFunctionCall, isValue bool) (opt.Optional[IRValue], error) {
+	switch symbol := call.symbol.(type) {
+	case *Variable:
+		callee := NodeTermIdentifier{identifier: call.ident, symbol: symbol}
+		return l.lowerIndirectCall(callee, call.params, isValue)
+
+	case Builtin:
+		return l.lowerBuiltin(call, symbol, isValue)
+
+	case *Function:
+		args, err := l.lowerArgs(call.params)
+		if err != nil {
+			return opt.Optional[IRValue]{}, err
+		}
+		if !isValue {
+			l.emit(IRCall{function: symbol, args: args})
+			return opt.Optional[IRValue]{}, nil
+		}
+		dest := l.function.newTemp()
+		l.emit(IRCall{dest: opt.ToOptional(dest), function: symbol, args: args})
+		return opt.ToOptional[IRValue](dest), nil
+
+	default:
+		panic(fmt.Errorf("lowering error: call bound to unexpected symbol: %T", call.symbol))
+	}
+}
+
+// as the return count of a function value isn't known until the program runs
+// the call expects one value if it's used as a value and throws away any
+// it's given otherwise
+func (l *Lowerer) lowerIndirectCall(callee NodeExpr, params []NodeExpr, isValue bool) (opt.Optional[IRValue], error) {
+	args, err := l.lowerArgs(params)
+	if err != nil {
+		return opt.Optional[IRValue]{}, err
+	}
+	calleeValue, err := l.lowerExpr(callee)
+	if err != nil {
+		return opt.Optional[IRValue]{}, err
+	}
+
+	if !isValue {
+		l.emit(IRCallIndirect{callee: calleeValue, args: args})
+		return opt.Optional[IRValue]{}, nil
+	}
+	dest := l.function.newTemp()
+	l.emit(IRCallIndirect{dest: opt.ToOptional(dest), callee: calleeValue, args: args})
+	return opt.ToOptional[IRValue](dest), nil
+}
+
+func (l *Lowerer) lowerBuiltin(call NodeFunctionCall, builtin Builtin, isValue bool) (opt.Optional[IRValue], error) {
+	var dest opt.Optional[IRTemp]
+	if builtin.returns == 1 {
+		dest = opt.ToOptional(l.function.newTemp())
+	}
+
+	switch builtin.name {
+	case "vaCount":
+		l.emit(IRVaCount{dest: dest.MustGetValue()})
+
+	case "vaArg":
+		index, err := l.lowerExpr(call.params[1])
+		if err != nil {
+			return opt.Optional[IRValue]{}, err
+		}
+		l.emit(IRVaArg{dest: dest.MustGetValue(), index: index})
+
+	case "alloc", "free":
+		arg, err := l.lowerExpr(call.params[0])
+		if err != nil {
+			return opt.Optional[IRValue]{}, err
+		}
+		l.emit(IRRuntimeCall{dest: dest, label: "molten_" + builtin.name, arg: arg})
+
+	case "errno", "argc", "argv", "envp":
+		l.emit(IRLoadGlobal{dest: dest.MustGetValue(), global: "molten_" + builtin.name})
+
+	default:
+		panic(fmt.Errorf("lowering error: don't know how to lower builtin: %s", builtin.name))
+	}
+
+	if !isValue || !dest.HasValue() {
+		return opt.Optional[IRValue]{}, nil
+	}
+	return opt.ToOptional[IRValue](dest.MustGetValue()), nil
+}
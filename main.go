@@ -2,6 +2,7 @@ package main
 
 import (
 	"errors"
+	"flag"
 	"fmt"
 	"os"
 	"os/exec"
@@ -11,56 +12,133 @@ import (
 // if true molten program will run els it will just compile to asm
 var ShouldRun = true
 
+var debugHeap = flag.Bool("heap-debug", false, "check for double frees and writes past the end of heap blocks")
+var errorFormat = flag.String("error-format", "text", "how errors are reported: text or json")
+var colour = flag.String("color", "auto", "colour errors: auto, always or never")
+var warnings = flag.String("W", "all", "comma separated warnings to report. `no-` before a name turns it off")
+var warningsAsErrors = flag.String("Werror", "", "comma separated warnings to report as errors. ones turned off with -W aren't reported at all")
+var optimisationLevel = flag.Int("O", 1, "how much to optimise: 0 for not at all or 1")
+var emitIR = flag.Bool("emit-ir", false, "write the IR the program is compiled from to build/<name>.ir")
+
 func main() {
 	err := checkCLA()
 	if err != nil {
 		fmt.Println(err.Error())
 		return
 	}
-	program, err := loadProgram("code/" + os.Args[1])
+	fileName := flag.Arg(0)
+
+	loader := NewLoader("code")
+	renderer := NewDiagnosticRenderer(loader.sources)
+	renderer.json = *errorFormat == "json"
+	renderer.colour = *colour == "always" || (*colour == "auto" && isColourTerminal(os.Stdout))
+
+	warningConfig, err := ParseWarningConfig(*warnings, *warningsAsErrors)
 	if err != nil {
-		fmt.Println(err.Error())
+		fmt.Println(renderer.Render(err))
 		return
 	}
 
-	tokeniser := NewTokeniser(program, os.Args[1])
-	tokens, err := tokeniser.Tokenise()
+	root, err := loader.LoadProg(fileName)
 	if err != nil {
-		fmt.Println(err.Error())
+		fmt.Println(renderer.Render(err))
 		return
 	}
 
-	parser := NewParser(tokens)
-	root, err := parser.ParseProg()
+	resolver := NewResolver(root)
+	root, err = resolver.Resolve()
 	if err != nil {
-		fmt.Println(err.Error())
+		fmt.Println(renderer.Render(err))
+		return
+	}
+
+	// warnings are rendered with any errors after them so
+	// there's only one list of them with -error-format=json
+	linter := NewLinter(root, warningConfig)
+	diagnostics := linter.Lint()
+	fail := func(err error) {
+		diagnostics.Add(err)
+		fmt.Println(renderer.Render(diagnostics))
+	}
+	if diagnostics.HasErrors() {
+		fmt.Println(renderer.Render(diagnostics))
+		return
+	}
+
+	lowerer := NewLowerer(root)
+	ir, err := lowerer.LowerProg()
+	if err != nil {
+		fail(err)
+		return
+	}
+
+	err = foldConstants(&ir)
+	if err != nil {
+		fail(err)
 		return
 	}
 
-	generator := NewGenerator(root)
+	inlined, err := inlineFunctions(&ir, *optimisationLevel >= 1)
+	if err != nil {
+		fail(err)
+		return
+	}
+	if inlined {
+		// arguments that are constants can be folded into the inlined code.
+		// dividing by 0 that only shows up then happens when the program
+		// runs, as it would have in the function
+		_ = foldConstants(&ir)
+	}
+	removeDeadCode(&ir)
+
+	if *emitIR {
+		err = writeToFile(strings.Split(fileName, ".")[0]+".ir", ir.String())
+		if err != nil {
+			fail(err)
+			return
+		}
+	}
+
+	generator := NewGenerator(ir)
+	generator.debugHeap = *debugHeap
+	generator.optimisationLevel = *optimisationLevel
 	asm, err := generator.GenProg()
 	if err != nil {
-		fmt.Println(err.Error())
+		fail(err)
 		return
 	}
 
-	err = writeToFile(strings.Split(os.Args[1], ".")[0], asm)
+	err = writeToFile(strings.Split(fileName, ".")[0]+".asm", asm)
 	if err != nil {
-		fmt.Println(err.Error())
+		fail(err)
 		return
 	}
 
+	if len(diagnostics) > 0 {
+		fmt.Println(renderer.Render(diagnostics))
+	}
+
 	if ShouldRun {
-		err = run(strings.Split(os.Args[1], ".")[0])
+		err = run(strings.Split(fileName, ".")[0], flag.Args()[1:])
 		if err != nil {
-			fmt.Println(err.Error())
+			fmt.Println(renderer.Render(err))
 		}
 	}
 }
 
 func checkCLA() error {
-	if len(os.Args) != 2 {
-		return errors.New("bad usage. correct usage is:\n\"molten <main.mltn>\"")
+	flag.Parse()
+	if *errorFormat != "text" && *errorFormat != "json" {
+		return errors.New("-error-format must be text or json")
+	}
+	if *colour != "auto" && *colour != "always" && *colour != "never" {
+		return errors.New("-color must be auto, always or never")
+	}
+	if *optimisationLevel < 0 || *optimisationLevel > 1 {
+		return errors.New("-O must be 0 or 1")
+	}
+	if flag.NArg() < 1 {
+		return errors.New("bad usage. correct usage is:\n\"molten [flags] <main.mltn> [program args...]\"")
 	}
 	return nil
 }
@@ -83,7 +161,7 @@ func writeToFile(filename string, asm string) error {
 		}
 	}
 
-	file, err := os.Create(buildDir + "/" + filename + ".asm")
+	file, err := os.Create(buildDir + "/" + filename)
 	if err != nil {
 		return err
 	}
@@ -96,8 +174,9 @@ func writeToFile(filename string, asm string) error {
 	return nil
 }
 
-func run(filename string) error {
-	cmd := exec.Command("./run.sh", filename+".asm")
+// runs the compiled program passing it args
+func run(filename string, args []string) error {
+	cmd := exec.Command("./run.sh", append([]string{filename + ".asm"}, args...)...)
 	cmd.Stdin = os.Stdin
 	cmd.Stdout = os.Stdout
 	cmd.Stderr = os.Stderr
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// loads and resolves a program written to main.mltn in a new directory
+func resolveSource(t *testing.T, source string) (NodeProg, error) {
+	t.Helper()
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "main.mltn"), []byte(source), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	loader := NewLoader(dir)
+	prog, err := loader.LoadProg("main.mltn")
+	if err != nil {
+		return NodeProg{}, err
+	}
+	resolver := NewResolver(prog)
+	return resolver.Resolve()
+}
+
+// the codes of every error in err
+func errorCodes(err error) []string {
+	errs := ErrorList{}
+	errs.Add(err)
+	codes := []string{}
+	for _, e := range errs {
+		if d, ok := e.(Diagnostic); ok {
+			codes = append(codes, d.code)
+		} else {
+			codes = append(codes, e.Error())
+		}
+	}
+	return codes
+}
+
+// the IR of a program that has to compile
+func lowerSource(t *testing.T, source string) IRProgram {
+	t.Helper()
+	prog, err := resolveSource(t, source)
+	if err != nil {
+		t.Fatalf("program didn't resolve: %v", err)
+	}
+	lowerer := NewLowerer(prog)
+	ir, err := lowerer.LowerProg()
+	if err != nil {
+		t.Fatalf("program didn't lower: %v", err)
+	}
+	return ir
+}
+
+// the instructions of a type in a function
+func findInstrs[T IRInstr](f *IRFunction) []T {
+	found := []T{}
+	for _, b := range f.blocks {
+		for _, instr := range b.instrs {
+			if i, ok := instr.(T); ok {
+				found = append(found, i)
+			}
+		}
+	}
+	return found
+}
+
+// the IR function for the function called name
+func findIRFunction(t *testing.T, program IRProgram, name string) *IRFunction {
+	t.Helper()
+	for _, f := range program.functions {
+		if f.function.name == name {
+			return f
+		}
+	}
+	t.Fatalf("no function called %s", name)
+	return nil
+}
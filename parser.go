@@ -2,6 +2,7 @@ package main
 
 import (
 	"errors"
+	"fmt"
 
 	opt "github.com/moltenwolfcub/moltenCompiler/optional"
 )
@@ -9,27 +10,36 @@ import (
 type Parser struct {
 	tokens       []Token
 	currentIndex int
+
+	// for positioning errors when there are no tokens
+	fileName string
+
+	errors ErrorList
 }
 
-func NewParser(tokens []Token) Parser {
+func NewParser(tokens []Token, fileName string) Parser {
 	return Parser{
-		tokens: tokens,
+		tokens:   tokens,
+		fileName: fileName,
 	}
 }
 
 func (p *Parser) ParseProg() (NodeProg, error) {
 	node := NodeProg{
-		[]NodeStmt{},
+		stmts: []NodeStmt{},
 	}
 	for p.peek().HasValue() {
+		start := p.currentIndex
 		stmt, err := p.ParseStmt()
 		if err != nil {
-			return NodeProg{}, p.error(err.Error())
+			p.errors.Add(p.positioned(err))
+			p.synchronise(start)
+			continue
 		}
 
 		node.stmts = append(node.stmts, stmt)
 	}
-	return node, nil
+	return node, p.errors.Err()
 }
 
 func (p *Parser) ParseStmt() (NodeStmt, error) {
@@ -39,7 +49,15 @@ func (p *Parser) ParseStmt() (NodeStmt, error) {
 		if err != nil {
 			return nil, err
 		}
-		node := NodeStmtVarDeclare{tok}
+		node := NodeStmtVarDeclare{ident: tok}
+
+		if p.mustTryConsume(equals).HasValue() {
+			expr, err := p.ParseExpr()
+			if err != nil {
+				return nil, err
+			}
+			node.expr = opt.ToOptional(expr)
+		}
 
 		_, err = p.tryConsume(semiColon, "missing ';'")
 		if err != nil {
@@ -49,7 +67,8 @@ func (p *Parser) ParseStmt() (NodeStmt, error) {
 		return node, nil
 	} else if p.peek().HasValue() && p.peek().MustGetValue().tokenType == identifier {
 		if !p.peek(1).HasValue() {
-			return nil, errors.New("expected '=' or '()' after identifier for variable assignment or function call. didn't find any token")
+			p.consume()
+			return nil, p.errorAfterPrevious(codeExpectedAssign, "expected '=' or '()' after identifier for variable assignment or function call. didn't find any token")
 		}
 
 		switch p.peek(1).MustGetValue().tokenType {
@@ -78,14 +97,19 @@ func (p *Parser) ParseStmt() (NodeStmt, error) {
 				return nil, err
 			}
 
+			call, err := p.ParseCallSuffix(funcCall)
+			if err != nil {
+				return nil, err
+			}
+
 			_, err = p.tryConsume(semiColon, "missing ';'")
 			if err != nil {
 				return nil, err
 			}
 
-			return funcCall, nil
+			return call.(NodeStmt), nil
 		default:
-			return nil, errors.New("expected '=' or '()' after identifier for variable assignment or function call")
+			return nil, p.errorAt(p.peek(1).MustGetValue(), codeExpectedAssign, "expected '=' or '()' after identifier for variable assignment or function call")
 		}
 	} else if p.mustTryConsume(asterisk).HasValue() {
 		tok, err := p.tryConsume(identifier, "expected variable identifier after '*'")
@@ -115,6 +139,25 @@ func (p *Parser) ParseStmt() (NodeStmt, error) {
 
 		return node, nil
 
+	} else if p.peek().HasValue() && p.peek().MustGetValue().tokenType == openRoundBracket {
+		bracket := p.peek().MustGetValue()
+		term, err := p.ParseTerm()
+		if err != nil {
+			return nil, err
+		}
+
+		call, ok := term.(NodeIndirectCall)
+		if !ok {
+			return nil, p.errorAt(bracket, codeExpectedCall, "expected a call after bracketed expression")
+		}
+
+		_, err = p.tryConsume(semiColon, "missing ';'")
+		if err != nil {
+			return nil, err
+		}
+
+		return call, nil
+
 	} else if p.peek().HasValue() && p.peek().MustGetValue().tokenType == openCurlyBracket {
 		scope, err := p.ParseScope()
 		if err != nil {
@@ -129,8 +172,8 @@ func (p *Parser) ParseStmt() (NodeStmt, error) {
 		}
 		return ifStmt, nil
 
-	} else if p.mustTryConsume(while).HasValue() {
-		node := NodeStmtWhile{}
+	} else if tok := p.mustTryConsume(while); tok.HasValue() {
+		node := NodeStmtWhile{while: tok.MustGetValue()}
 
 		_, err := p.tryConsume(openRoundBracket, "Expected '('")
 		if err != nil {
@@ -167,8 +210,15 @@ func (p *Parser) ParseStmt() (NodeStmt, error) {
 		}
 		return NodeStmtContinue{tok.MustGetValue()}, nil
 
-	} else if p.mustTryConsume(_func).HasValue() {
-		node := NodeStmtFunctionDefinition{}
+	} else if inline := p.mustTryConsume(_inline); inline.HasValue() || p.mustTryConsume(_func).HasValue() {
+		node := NodeStmtFunctionDefinition{inline: inline}
+
+		if inline.HasValue() {
+			_, err := p.tryConsume(_func, "expected `func` after `inline`")
+			if err != nil {
+				return nil, err
+			}
+		}
 
 		count, err := p.tryConsume(intLiteral, "expected an int for the number of returns")
 		if err != nil {
@@ -188,6 +238,15 @@ func (p *Parser) ParseStmt() (NodeStmt, error) {
 		}
 
 		for {
+			if p.mustTryConsume(ellipsis).HasValue() {
+				ident, err := p.tryConsume(identifier, "expected identifier after '...' for variadic parameter")
+				if err != nil {
+					return nil, err
+				}
+				node.variadic = opt.ToOptional(ident)
+				break
+			}
+
 			ident, err := p.tryConsume(identifier, "optional so this should never error")
 			if err != nil {
 				break
@@ -200,7 +259,11 @@ func (p *Parser) ParseStmt() (NodeStmt, error) {
 			}
 		}
 
-		_, err = p.tryConsume(closeRoundBracket, "missing ')'")
+		if node.variadic.HasValue() {
+			_, err = p.tryConsume(closeRoundBracket, "the variadic parameter must be the last parameter")
+		} else {
+			_, err = p.tryConsume(closeRoundBracket, "missing ')'")
+		}
 		if err != nil {
 			return nil, err
 		}
@@ -240,35 +303,67 @@ func (p *Parser) ParseStmt() (NodeStmt, error) {
 	} else if tok := p.mustTryConsume(syscall); tok.HasValue() {
 		node := NodeStmtSyscall{syscall: tok.MustGetValue()}
 
-		_, err := p.tryConsume(openRoundBracket, "Expected '('")
+		args, err := p.ParseSyscallArgs(tok.MustGetValue())
 		if err != nil {
 			return nil, err
 		}
+		node.arguments = args
 
-		for {
-			expr, err := p.ParseExpr()
-			if err == errMissingExpr {
-				break
-			} else if err != nil {
-				return NodeStmtSyscall{}, err
+		_, err = p.tryConsume(semiColon, "missing ';'")
+		if err != nil {
+			return nil, err
+		}
+
+		return node, nil
+
+	} else if tok := p.mustTryConsume(_import); tok.HasValue() {
+		path, err := p.tryConsume(stringLiteral, "expected file path string after `import`")
+		if err != nil {
+			return nil, err
+		}
+
+		_, err = p.tryConsume(semiColon, "missing ';'")
+		if err != nil {
+			return nil, err
+		}
+
+		return NodeStmtImport{_import: tok.MustGetValue(), path: path}, nil
+
+	} else if tok := p.mustTryConsume(asm); tok.HasValue() {
+		node := NodeStmtAsm{asm: tok.MustGetValue()}
+
+		if p.mustTryConsume(openRoundBracket).HasValue() {
+			for {
+				reg, err := p.tryConsume(identifier, "optional so this should never error")
+				if err != nil {
+					break
+				}
+				node.clobbers = append(node.clobbers, reg)
+
+				_, err = p.tryConsume(comma, "optional so this should never error")
+				if err != nil {
+					break
+				}
 			}
-			node.arguments = append(node.arguments, expr)
 
-			_, err = p.tryConsume(comma, "optional so this should never error")
+			_, err := p.tryConsume(closeRoundBracket, "expected ')' after clobbered registers")
 			if err != nil {
-				break
+				return nil, err
 			}
 		}
-		if len(node.arguments) > 7 {
-			return nil, errSyscallArgs
+
+		_, err := p.tryConsume(openCurlyBracket, "expected '{' after `asm`")
+		if err != nil {
+			return nil, err
 		}
 
-		_, err = p.tryConsume(closeRoundBracket, "Expected ')'")
+		body, err := p.tryConsume(asmBody, "expected asm body")
 		if err != nil {
 			return nil, err
 		}
+		node.body = body
 
-		_, err = p.tryConsume(semiColon, "missing ';'")
+		_, err = p.tryConsume(closeCurlyBracket, "expected '}'")
 		if err != nil {
 			return nil, err
 		}
@@ -280,20 +375,70 @@ func (p *Parser) ParseStmt() (NodeStmt, error) {
 	}
 }
 
-var errSyscallArgs error = errors.New("syscalls can't have more than 7 arguments")
+func (p *Parser) ParseSyscallArgs(syscallTok Token) ([]NodeExpr, error) {
+	args := []NodeExpr{}
+
+	_, err := p.tryConsume(openRoundBracket, "Expected '('")
+	if err != nil {
+		return nil, err
+	}
+
+	for {
+		expr, err := p.ParseExpr()
+		if err == errMissingExpr {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+		args = append(args, expr)
+
+		_, err = p.tryConsume(comma, "optional so this should never error")
+		if err != nil {
+			break
+		}
+	}
+	if len(args) > 7 {
+		return nil, p.errorAt(syscallTok, codeTooManyArgs, "syscalls can't have more than 7 arguments")
+	}
+
+	_, err = p.tryConsume(closeRoundBracket, "Expected ')'")
+	if err != nil {
+		return nil, err
+	}
+
+	return args, nil
+}
+
 var errMissingStmt error = errors.New("expected statement but couldn't find one")
 
 func (p *Parser) ParseTerm() (NodeTerm, error) {
+	term, err := p.parsePrimaryTerm()
+	if err != nil {
+		return nil, err
+	}
+
+	switch term.(type) {
+	case NodeFunctionCall, NodeTermRoundBracketExpr, NodeTermPointerDereference:
+		return p.ParseCallSuffix(term)
+	default:
+		return term, nil
+	}
+}
+
+func (p *Parser) parsePrimaryTerm() (NodeTerm, error) {
 	if tok := p.mustTryConsume(intLiteral); tok.HasValue() {
 		return NodeTermIntLiteral{tok.MustGetValue()}, nil
 	} else if p.peek().HasValue() && p.peek().MustGetValue().tokenType == identifier {
-		if p.peek(1).MustGetValue().tokenType == openRoundBracket {
+		if p.peek(1).HasValue() && p.peek(1).MustGetValue().tokenType == openRoundBracket {
 			return p.ParseFuncCall()
 		} else {
-			return NodeTermIdentifier{p.consume()}, nil
+			return NodeTermIdentifier{identifier: p.consume()}, nil
 		}
 	} else if p.mustTryConsume(openRoundBracket).HasValue() {
 		expr, err := p.ParseExpr()
+		if err == errMissingExpr {
+			return nil, p.errorMissing(codeExpectedExpr, "expected expression after '('")
+		}
 		if err != nil {
 			return nil, err
 		}
@@ -302,18 +447,24 @@ func (p *Parser) ParseTerm() (NodeTerm, error) {
 			return nil, err
 		}
 		return NodeTermRoundBracketExpr{expr}, nil
+	} else if tok := p.mustTryConsume(syscall); tok.HasValue() {
+		args, err := p.ParseSyscallArgs(tok.MustGetValue())
+		if err != nil {
+			return nil, err
+		}
+		return NodeTermSyscall{syscall: tok.MustGetValue(), arguments: args}, nil
 	} else if p.mustTryConsume(ampersand).HasValue() {
 		variable, err := p.tryConsume(identifier, "expected variable identifier after '&'")
 		if err != nil {
 			return nil, err
 		}
-		return NodeTermPointer{variable}, nil
+		return NodeTermPointer{identifier: variable}, nil
 	} else if p.mustTryConsume(asterisk).HasValue() {
 		variable, err := p.tryConsume(identifier, "expected variable identifier after '*'")
 		if err != nil {
 			return nil, err
 		}
-		return NodeTermPointerDereference{variable}, nil
+		return NodeTermPointerDereference{identifier: variable}, nil
 	}
 	return nil, errMissingTerm
 }
@@ -354,38 +505,78 @@ func (p *Parser) ParseFuncCall() (NodeFunctionCall, error) {
 	return node, nil
 }
 
+// parses any number of `(args)` following a term so that
+// function values can be called, E.G. `getCmp()(a, b)` or `(*ptr)(a)`
+func (p *Parser) ParseCallSuffix(callee NodeTerm) (NodeTerm, error) {
+	for p.peek().HasValue() && p.peek().MustGetValue().tokenType == openRoundBracket {
+		node := NodeIndirectCall{
+			callee:  callee,
+			bracket: p.consume(),
+		}
+
+		for {
+			expr, err := p.ParseExpr()
+			if err == errMissingExpr {
+				break
+			} else if err != nil {
+				return nil, err
+			}
+			node.params = append(node.params, expr)
+
+			_, err = p.tryConsume(comma, "optional so this should never error")
+			if err != nil {
+				break
+			}
+		}
+
+		_, err := p.tryConsume(closeRoundBracket, "missing ')'")
+		if err != nil {
+			return nil, err
+		}
+
+		callee = node
+	}
+	return callee, nil
+}
+
 func (p *Parser) ParseScope() (NodeScope, error) {
-	if !p.mustTryConsume(openCurlyBracket).HasValue() {
-		return NodeScope{}, nil
+	open := p.mustTryConsume(openCurlyBracket)
+	if !open.HasValue() {
+		return NodeScope{}, errMissingScopeStmt
 	}
 
-	var scope NodeScope
+	scope := NodeScope{openBrace: open.MustGetValue()}
 	for {
+		start := p.currentIndex
 		stmt, err := p.ParseStmt()
-		if err == errMissingStmt {
+		if err == errMissingStmt && (!p.peek().HasValue() || p.peek().MustGetValue().tokenType == closeCurlyBracket) {
 			break
 		} else if err != nil {
-			return NodeScope{}, err
+			p.errors.Add(p.positioned(err))
+			p.synchronise(start)
+			continue
 		}
 
 		scope.stmts = append(scope.stmts, stmt)
 	}
-	_, err := p.tryConsume(closeCurlyBracket, "expected '}'")
+	closeBrace, err := p.tryConsume(closeCurlyBracket, "expected '}'")
 	if err != nil {
 		return NodeScope{}, err
 	}
+	scope.closeBrace = closeBrace
 
 	return scope, nil
 }
 
-var errMissingScopeStmt error = errors.New("expected a scope statement but didn't find an open brace")
+var errMissingScopeStmt error = errors.New("expected '{'")
 
 func (p *Parser) ParseIf() (NodeStmtIf, error) {
-	if !p.mustTryConsume(_if).HasValue() {
+	ifTok := p.mustTryConsume(_if)
+	if !ifTok.HasValue() {
 		return NodeStmtIf{}, errMissingIfStmt
 	}
 
-	node := NodeStmtIf{}
+	node := NodeStmtIf{_if: ifTok.MustGetValue()}
 
 	_, err := p.tryConsume(openRoundBracket, "Expected '('")
 	if err != nil {
@@ -429,7 +620,7 @@ func (p *Parser) ParseElse() (opt.Optional[NodeElse], error) {
 	if err == errMissingIfStmt {
 		scope, err := p.ParseScope()
 		if err == errMissingScopeStmt {
-			return opt.Optional[NodeElse]{}, errors.New("expected scope or else-if statement following `else` keyword")
+			return opt.Optional[NodeElse]{}, p.error(codeExpectedElse, "expected scope or else-if statement following `else` keyword")
 
 		} else if err != nil {
 			return opt.Optional[NodeElse]{}, err
@@ -479,7 +670,11 @@ func (p *Parser) ParseExpr(minPrecedence ...int) (NodeExpr, error) {
 
 		nextMinPrec := currentPrec.MustGetValue() + 1
 
+		// the operator means there has to be an expression after it
 		rhsExpr, err := p.ParseExpr(nextMinPrec)
+		if err == errMissingExpr {
+			return nil, p.errorMissing(codeExpectedExpr, fmt.Sprintf("expected expression after '%s'", op.text()))
+		}
 		if err != nil {
 			return nil, err
 		}
@@ -544,7 +739,7 @@ func (p *Parser) tryConsume(tokType TokenType, errMsg string) (Token, error) {
 	if p.peek().HasValue() && p.peek().MustGetValue().tokenType == tokType {
 		return p.consume(), nil
 	} else {
-		return Token{}, errors.New(errMsg)
+		return Token{}, p.errorMissing(codeExpectedToken, errMsg)
 	}
 }
 func (p *Parser) mustTryConsume(tokType TokenType) opt.Optional[Token] {
@@ -555,18 +750,112 @@ func (p *Parser) mustTryConsume(tokType TokenType) opt.Optional[Token] {
 	}
 }
 
-func (p *Parser) error(message string) error {
-	var lineInfo LineInfo
-	if p.currentIndex < len(p.tokens) {
-		lineInfo = p.tokens[p.currentIndex].lineInfo
-	} else {
-		lineInfo = p.tokens[len(p.tokens)-1].lineInfo
+// skips past the rest of a statement that failed to parse so the statements
+// after it can still be checked. a statement ends at a ';' or after a block
+// and the next one can start with a keyword
+func (p *Parser) synchronise(start int) {
+	for p.peek().HasValue() {
+		tok := p.peek().MustGetValue()
+		switch tok.tokenType {
+		case semiColon:
+			p.consume()
+			return
+		case openCurlyBracket:
+			p.skipBlock()
+			if !p.peek().HasValue() || p.peek().MustGetValue().tokenType != _else {
+				return
+			}
+		case closeCurlyBracket:
+			// a stray '}' in top level code
+			if p.currentIndex == start {
+				p.consume()
+			}
+			return
+		case _var, _if, while, _func, _inline, _return, _break, _continue, _import, asm:
+			if p.currentIndex != start {
+				return
+			}
+			p.consume()
+		default:
+			p.consume()
+		}
+	}
+}
+
+func (p *Parser) skipBlock() {
+	depth := 0
+	for p.peek().HasValue() {
+		switch p.consume().tokenType {
+		case openCurlyBracket:
+			depth++
+		case closeCurlyBracket:
+			depth--
+			if depth == 0 {
+				return
+			}
+		}
+	}
+}
+
+// an error at the next token or at the end of the file if there isn't one
+func (p *Parser) error(code string, message string) error {
+	if !p.peek().HasValue() {
+		return p.errorAfterPrevious(code, message)
+	}
+	return p.errorAt(p.peek().MustGetValue(), code, message)
+}
+
+func (p *Parser) errorAt(tok Token, code string, message string) error {
+	return tok.PositionedError(code, message)
+}
+
+// an error just after the last token consumed
+func (p *Parser) errorAfterPrevious(code string, message string) error {
+	if p.currentIndex == 0 {
+		if len(p.tokens) > 0 {
+			return p.errorAt(p.tokens[0], code, message)
+		}
+		return NewLineInfo(p.fileName).PositionedError(code, message)
+	}
+	return p.tokens[p.currentIndex-1].end().PositionedError(code, message)
+}
+
+// an error about something that should have come next. if the next token
+// is on a later line the missing part belongs at the end of the line before
+// so the error is put straight after the previous token
+func (p *Parser) errorMissing(code string, message string) error {
+	if p.peek().HasValue() && p.currentIndex > 0 && p.peek().MustGetValue().lineInfo.Line == p.tokens[p.currentIndex-1].end().Line {
+		return p.error(code, message)
+	}
+	return p.errorAfterPrevious(code, message)
+}
+
+// gives the sentinel errors used to backtrack the position they're reported at
+func (p *Parser) positioned(err error) error {
+	switch err {
+	case errMissingExpr, errMissingTerm:
+		return p.errorMissing(codeExpectedExpr, err.Error())
+	case errMissingScopeStmt:
+		return p.errorMissing(codeExpectedScope, err.Error())
+	case errMissingStmt, errMissingIfStmt:
+		return p.error(codeExpectedStmt, err.Error())
+	default:
+		return err
 	}
-	return lineInfo.PositionedError(message)
 }
 
 type NodeProg struct {
 	stmts []NodeStmt
+
+	// top level code of imported files
+	init []NodeStmt
+
+	// every file that makes up the program by file name
+	files    map[string]SourceFile
+	mainFile string
+
+	// the entry point if the main file has a main function. set by the resolver
+	main opt.Optional[*Function]
 }
 
 type NodeStmt interface {
@@ -575,6 +864,9 @@ type NodeStmt interface {
 
 type NodeStmtVarDeclare struct {
 	ident Token
+	expr  opt.Optional[NodeExpr]
+
+	variable *Variable
 }
 
 func (NodeStmtVarDeclare) IsNodeStmt() {}
@@ -582,6 +874,8 @@ func (NodeStmtVarDeclare) IsNodeStmt() {}
 type NodeStmtVarAssign struct {
 	ident Token
 	expr  NodeExpr
+
+	variable *Variable
 }
 
 func (NodeStmtVarAssign) IsNodeStmt() {}
@@ -589,11 +883,14 @@ func (NodeStmtVarAssign) IsNodeStmt() {}
 type NodeStmtPointerAssign struct {
 	ident Token
 	expr  NodeExpr
+
+	variable *Variable
 }
 
 func (NodeStmtPointerAssign) IsNodeStmt() {}
 
 type NodeStmtIf struct {
+	_if        Token
 	expr       NodeExpr
 	scope      NodeScope
 	elseBranch opt.Optional[NodeElse]
@@ -602,6 +899,7 @@ type NodeStmtIf struct {
 func (NodeStmtIf) IsNodeStmt() {}
 
 type NodeStmtWhile struct {
+	while Token
 	expr  NodeExpr
 	scope NodeScope
 }
@@ -621,10 +919,15 @@ type NodeStmtContinue struct {
 func (NodeStmtContinue) IsNodeStmt() {}
 
 type NodeStmtFunctionDefinition struct {
-	ident   Token
-	params  []Token
-	returns string
-	body    NodeScope
+	inline   opt.Optional[Token]
+	ident    Token
+	params   []Token
+	variadic opt.Optional[Token]
+	returns  string
+	body     NodeScope
+
+	function   *Function
+	parameters []*Variable
 }
 
 func (NodeStmtFunctionDefinition) IsNodeStmt() {}
@@ -632,6 +935,9 @@ func (NodeStmtFunctionDefinition) IsNodeStmt() {}
 type NodeStmtReturn struct {
 	returns []NodeExpr
 	_return Token
+
+	// nil when returning from top level code
+	function *Function
 }
 
 func (NodeStmtReturn) IsNodeStmt() {}
@@ -643,6 +949,26 @@ type NodeStmtSyscall struct {
 
 func (NodeStmtSyscall) IsNodeStmt() {}
 
+type NodeStmtImport struct {
+	_import Token
+	path    Token
+}
+
+func (NodeStmtImport) IsNodeStmt() {}
+
+// inline assembly. `%name` in the body is replaced with the
+// operand of a molten variable and `%%` with a literal '%'
+type NodeStmtAsm struct {
+	asm      Token
+	clobbers []Token
+	body     Token
+
+	// the variable each placeholder refers to by name
+	variables map[string]*Variable
+}
+
+func (NodeStmtAsm) IsNodeStmt() {}
+
 type NodeExpr interface {
 	IsNodeExpr()
 }
@@ -706,20 +1032,36 @@ func (NodeTermIntLiteral) IsNodeExpr() {}
 
 type NodeTermIdentifier struct {
 	identifier Token
+
+	symbol Symbol
 }
 
 func (NodeTermIdentifier) IsNodeTerm() {}
 func (NodeTermIdentifier) IsNodeExpr() {}
 
+// symbol is the function or builtin called, or a variable holding a function value
 type NodeFunctionCall struct {
 	ident  Token
 	params []NodeExpr
+
+	symbol Symbol
 }
 
 func (NodeFunctionCall) IsNodeStmt() {}
 func (NodeFunctionCall) IsNodeTerm() {}
 func (NodeFunctionCall) IsNodeExpr() {}
 
+// a call through a function value rather than a function name
+type NodeIndirectCall struct {
+	callee  NodeExpr
+	params  []NodeExpr
+	bracket Token
+}
+
+func (NodeIndirectCall) IsNodeStmt() {}
+func (NodeIndirectCall) IsNodeTerm() {}
+func (NodeIndirectCall) IsNodeExpr() {}
+
 type NodeTermRoundBracketExpr struct {
 	expr NodeExpr
 }
@@ -727,8 +1069,20 @@ type NodeTermRoundBracketExpr struct {
 func (NodeTermRoundBracketExpr) IsNodeTerm() {}
 func (NodeTermRoundBracketExpr) IsNodeExpr() {}
 
+// a syscall used as a value gives what the kernel returned in rax.
+// errors give -1 with the error number available from `errno()`
+type NodeTermSyscall struct {
+	arguments []NodeExpr
+	syscall   Token
+}
+
+func (NodeTermSyscall) IsNodeTerm() {}
+func (NodeTermSyscall) IsNodeExpr() {}
+
 type NodeTermPointer struct {
 	identifier Token
+
+	symbol Symbol
 }
 
 func (NodeTermPointer) IsNodeTerm() {}
@@ -736,13 +1090,17 @@ func (NodeTermPointer) IsNodeExpr() {}
 
 type NodeTermPointerDereference struct {
 	identifier Token
+
+	variable *Variable
 }
 
 func (NodeTermPointerDereference) IsNodeTerm() {}
 func (NodeTermPointerDereference) IsNodeExpr() {}
 
 type NodeScope struct {
-	stmts []NodeStmt
+	openBrace  Token
+	stmts      []NodeStmt
+	closeBrace Token
 }
 
 func (NodeScope) IsNodeStmt() {}
@@ -762,3 +1120,76 @@ type NodeElseScope struct {
 }
 
 func (NodeElseScope) IsNodeElif() {}
+
+// the first token of a statement, for errors about the whole statement
+func stmtToken(rawStmt NodeStmt) Token {
+	switch stmt := rawStmt.(type) {
+	case NodeStmtVarDeclare:
+		return stmt.ident
+	case NodeStmtVarAssign:
+		return stmt.ident
+	case NodeStmtPointerAssign:
+		return stmt.ident
+	case NodeScope:
+		return stmt.openBrace
+	case NodeStmtIf:
+		return stmt._if
+	case NodeStmtWhile:
+		return stmt.while
+	case NodeStmtBreak:
+		return stmt._break
+	case NodeStmtContinue:
+		return stmt._continue
+	case NodeStmtFunctionDefinition:
+		return stmt.ident
+	case NodeStmtReturn:
+		return stmt._return
+	case NodeStmtSyscall:
+		return stmt.syscall
+	case NodeStmtImport:
+		return stmt._import
+	case NodeStmtAsm:
+		return stmt.asm
+	case NodeFunctionCall:
+		return stmt.ident
+	case NodeIndirectCall:
+		return exprToken(stmt.callee)
+	default:
+		panic(fmt.Errorf("parser error: don't know the position of statement: %T", rawStmt))
+	}
+}
+
+// the first token of an expression that has one. bracketed
+// expressions don't keep their bracket so give their inner expression's
+func exprToken(rawExpr NodeExpr) Token {
+	switch expr := rawExpr.(type) {
+	case NodeBinExprAdd:
+		return exprToken(expr.left)
+	case NodeBinExprSubtract:
+		return exprToken(expr.left)
+	case NodeBinExprMultiply:
+		return exprToken(expr.left)
+	case NodeBinExprDivide:
+		return exprToken(expr.left)
+	case NodeBinExprModulo:
+		return exprToken(expr.left)
+	case NodeTermIntLiteral:
+		return expr.intLiteral
+	case NodeTermIdentifier:
+		return expr.identifier
+	case NodeFunctionCall:
+		return expr.ident
+	case NodeIndirectCall:
+		return exprToken(expr.callee)
+	case NodeTermRoundBracketExpr:
+		return exprToken(expr.expr)
+	case NodeTermSyscall:
+		return expr.syscall
+	case NodeTermPointer:
+		return expr.identifier
+	case NodeTermPointerDereference:
+		return expr.identifier
+	default:
+		panic(fmt.Errorf("parser error: don't know the position of expression: %T", rawExpr))
+	}
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"testing"
+)
+
+// where each error is and what it says, as line:column message
+func parseErrors(t *testing.T, source string) []string {
+	t.Helper()
+	tokeniser := NewTokeniser(source, "main.mltn")
+	tokens, err := tokeniser.Tokenise()
+	if err != nil {
+		t.Fatalf("program didn't tokenise: %v", err)
+	}
+	parser := NewParser(tokens, "main.mltn")
+	_, err = parser.ParseProg()
+
+	errs := ErrorList{}
+	errs.Add(err)
+	found := []string{}
+	for _, e := range errs {
+		d := e.(Diagnostic)
+		found = append(found, fmt.Sprintf("%d:%d %s", d.span.start.Line, d.span.start.Col, d.message))
+	}
+	return found
+}
+
+func TestParse(t *testing.T) {
+	tests := []string{
+		"var x = 1 + 2 * (3 - 4) / 5 % 6;",
+		"func 1 f(a, b) { return a + b; } f(1, 2);",
+		"func 0 g(...xs) { vaCount(xs); }",
+		"var p = &x; *p = 2; var f = g; f(1)(2); (*p)(3);",
+		"if (x) { y = 1; } else if (z) { y = 2; } else { y = 3; }",
+		"while (1) { break; continue; }",
+		"syscall(60, 0); var r = syscall(1, 1, &c, 1);",
+		"asm (rbx) { mov rbx, %x }",
+		`import "lib/a.mltn";`,
+	}
+	for _, source := range tests {
+		t.Run(source, func(t *testing.T) {
+			if errs := parseErrors(t, source); len(errs) > 0 {
+				t.Errorf("unexpected errors: %q", errs)
+			}
+		})
+	}
+}
+
+func TestParseRecovery(t *testing.T) {
+	tests := []struct {
+		name   string
+		source string
+		want   []string
+	}{
+		{"missing semicolon", "var x = 1\nvar y = 2;", []string{"1:10 missing ';'"}},
+		{"every statement", "var = 1;\nvar y = ;\nvar z = 3;", []string{"1:5 expected variable identifier after `var`", "2:9 expected expression"}},
+		{"operand after operator", "f(1 +);", []string{"1:6 expected expression after '+'"}},
+		{"return operand", "func 1 f() {\n\treturn 1 *;\n}", []string{"2:12 expected expression after '*'"}},
+		{"syscall operand", "syscall(60, 2 -);", []string{"1:16 expected expression after '-'"}},
+		{"bracket", "var x = (;", []string{"1:10 expected expression after '('"}},
+		{"after a block", "while (x) { y = ; }\nvar z = 1;\nz = ;", []string{"1:17 expected expression", "3:5 expected expression"}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := parseErrors(t, test.source); !slices.Equal(got, test.want) {
+				t.Errorf("got %q, want %q", got, test.want)
+			}
+		})
+	}
+}
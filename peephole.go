@@ -0,0 +1,529 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+/*
+	The peephole optimiser looks at a couple of instructions at a time and
+	replaces them with fewer or cheaper ones that do the same, going over
+	a function until nothing else can be changed. Comments are skipped
+	over but labels aren't, as something could jump to them.
+
+	Some rewrites need to know a register isn't read again before it's
+	written, which is found by following the jumps between the lines of
+	the function. Calls keep the callee saved registers and can change the
+	rest, and inline assembly is taken to read every register.
+
+	It's used from `-O=1`, which is the default.
+*/
+
+func peephole(lines []AsmLine) []AsmLine {
+	for changed := true; changed; {
+		lines, changed = peepholePass(lines)
+	}
+	return lines
+}
+
+// the index of the next line from i on that isn't a comment, or -1
+func nextAsmLine(lines []AsmLine, i int) int {
+	for ; i < len(lines); i++ {
+		if _, isComment := lines[i].(AsmComment); !isComment {
+			return i
+		}
+	}
+	return -1
+}
+
+// the instruction at i or false if it isn't one
+func asmInstrAt(lines []AsmLine, i int) (AsmInstr, bool) {
+	if i < 0 || i >= len(lines) {
+		return AsmInstr{}, false
+	}
+	instr, ok := lines[i].(AsmInstr)
+	return instr, ok
+}
+
+// rewrites everything it can that doesn't overlap. a rewrite only
+// makes registers live between the lines it replaces so the liveness
+// from the start of the pass still holds for the rest of them
+func peepholePass(lines []AsmLine) ([]AsmLine, bool) {
+	live := findAsmLiveness(lines)
+
+	output := []AsmLine{}
+	changed := false
+	for i := 0; i < len(lines); i++ {
+		replaced, end, ok := rewriteAt(lines, live, i)
+		if !ok {
+			output = append(output, lines[i])
+			continue
+		}
+		output = append(output, replaced...)
+		i = end
+		changed = true
+	}
+	return output, changed
+}
+
+// the lines to replace the ones from i to end with, if any
+// rewrite starts at i. comments in between are kept
+func rewriteAt(lines []AsmLine, live asmLiveness, i int) (replaced []AsmLine, end int, ok bool) {
+	first, ok := asmInstrAt(lines, i)
+	if !ok {
+		return nil, 0, false
+	}
+	j := nextAsmLine(lines, i+1)
+	second, hasSecond := asmInstrAt(lines, j)
+
+	if replaced, ok := rewriteAlone(lines, i, first); ok {
+		return replaced, i, true
+	}
+	if forwarded, next, ok := forwardCopy(lines, live, i, first); ok {
+		return slices.Concat(lines[i+1:next], []AsmLine{forwarded}), next, true
+	}
+	if !hasSecond {
+		return nil, 0, false
+	}
+
+	if replaced, ok := rewritePair(live, j, first, second); ok {
+		return slices.Concat(lines[i+1:j], replaced), j, true
+	}
+
+	k := nextAsmLine(lines, j+1)
+	third, hasThird := asmInstrAt(lines, k)
+	if !hasThird {
+		return nil, 0, false
+	}
+	if replaced, ok := rewriteTriple(live, k, first, second, third); ok {
+		return slices.Concat(lines[i+1:j], lines[j+1:k], replaced), k, true
+	}
+	return nil, 0, false
+}
+
+// rewrites of one instruction
+func rewriteAlone(lines []AsmLine, i int, instr AsmInstr) ([]AsmLine, bool) {
+	switch instr.op {
+	case "mov":
+		// mov rax, rax
+		if instr.operands[0] == instr.operands[1] {
+			return []AsmLine{}, true
+		}
+
+	case "add", "sub":
+		// add rsp, 0
+		if instr.operands[1] == "0" {
+			return []AsmLine{}, true
+		}
+
+	case "jmp", "je", "jne", "jz", "jnz", "jb", "jae":
+		// jumps to the line after
+		for next := i + 1; next < len(lines); next++ {
+			switch line := lines[next].(type) {
+			case AsmComment:
+				continue
+			case AsmLabel:
+				if line.name == instr.operands[0] {
+					return []AsmLine{}, true
+				}
+				continue
+			}
+			break
+		}
+	}
+	return nil, false
+}
+
+// rewrites of two instructions. second is at j
+func rewritePair(live asmLiveness, j int, first AsmInstr, second AsmInstr) ([]AsmLine, bool) {
+	// push rax
+	// pop rbx
+	if first.op == "push" && second.op == "pop" {
+		src := strings.TrimPrefix(first.operands[0], "QWORD ")
+		dest := second.operands[0]
+		if slices.Contains(operandRegisters(src), "rsp") || slices.Contains(operandRegisters(dest), "rsp") {
+			return nil, false
+		}
+		if src == dest {
+			return []AsmLine{}, true
+		}
+		if move, ok := asmMove(dest, src); ok {
+			return []AsmLine{move}, true
+		}
+		return nil, false
+	}
+
+	if first.op != "mov" {
+		return nil, false
+	}
+	dest, src := first.operands[0], first.operands[1]
+
+	// mov [rbp - 8], rax
+	// mov rax, [rbp - 8]
+	if second.op == "mov" && second.operands[0] == src && strings.TrimPrefix(second.operands[1], "QWORD ") == strings.TrimPrefix(dest, "QWORD ") {
+		reg, memory := src, dest
+		if isAsmRegister(dest) {
+			reg, memory = dest, src
+		}
+		if isAsmRegister(reg) && !slices.Contains(operandRegisters(memory), reg) {
+			return []AsmLine{first}, true
+		}
+	}
+
+	if !isAsmRegister(dest) {
+		return nil, false
+	}
+
+	// mov rax, 1
+	// mov rax, 2
+	reads, writes := asmInstrRegisters(second)
+	if writes[dest] && !reads[dest] {
+		return []AsmLine{second}, true
+	}
+
+	return nil, false
+}
+
+// mov rsi, rbx
+// mov r8, r12
+// sub r9, rsi
+// uses rbx in the sub instead when rsi isn't needed after it.
+// the copy is forwarded past instructions that don't use either
+// but not past jumps or labels, as the copy is only known to be
+// unneeded on the way to the use.
+// gives the instruction to replace the use, which is at next
+func forwardCopy(lines []AsmLine, live asmLiveness, i int, copy AsmInstr) (forwarded AsmInstr, next int, ok bool) {
+	if copy.op != "mov" || !isAsmRegister(copy.operands[0]) {
+		return AsmInstr{}, 0, false
+	}
+	copied, src := copy.operands[0], copy.operands[1]
+	if slices.Contains(operandRegisters(src), "rsp") || slices.Contains(operandRegisters(src), copied) {
+		return AsmInstr{}, 0, false
+	}
+
+	for next := i + 1; next < len(lines); next++ {
+		if _, isComment := lines[next].(AsmComment); isComment {
+			continue
+		}
+		use, ok := lines[next].(AsmInstr)
+		if !ok || isAsmJump(use.op) {
+			return AsmInstr{}, 0, false
+		}
+
+		reads, writes := asmInstrRegisters(use)
+		if !reads[copied] && !writes[copied] {
+			// memory could be written by anything in between
+			if isMemoryOperand(src) {
+				return AsmInstr{}, 0, false
+			}
+			for _, reg := range operandRegisters(src) {
+				if writes[reg] {
+					return AsmInstr{}, 0, false
+				}
+			}
+			continue
+		}
+
+		forwarded, ok := substituteSource(use, copied, src)
+		if !ok || live[next][copied] {
+			return AsmInstr{}, 0, false
+		}
+		return forwarded, next, true
+	}
+	return AsmInstr{}, 0, false
+}
+
+// the instruction reading src where it read reg as its source operand, if it can
+func substituteSource(instr AsmInstr, reg string, src string) (AsmInstr, bool) {
+	switch instr.op {
+	case "mov", "add", "sub", "cmp":
+		dest := instr.operands[0]
+		if instr.operands[1] != reg || slices.Contains(operandRegisters(dest), reg) {
+			return AsmInstr{}, false
+		}
+		if isAsmRegister(src) {
+			return asmInstr(instr.op, dest, src), true
+		}
+		if isImmediate32(src) || (isImmediate(src) && instr.op == "mov" && isAsmRegister(dest)) {
+			if !isAsmRegister(dest) && !strings.HasPrefix(dest, "QWORD ") {
+				dest = "QWORD " + dest
+			}
+			return asmInstr(instr.op, dest, src), true
+		}
+		if isMemoryOperand(src) && isAsmRegister(dest) {
+			return asmInstr(instr.op, dest, src), true
+		}
+
+	case "push":
+		if instr.operands[0] != reg {
+			return AsmInstr{}, false
+		}
+		if isAsmRegister(src) || isImmediate32(src) {
+			return asmInstr("push", src), true
+		}
+		if isMemoryOperand(src) {
+			return asmInstr("push", "QWORD "+strings.TrimPrefix(src, "QWORD ")), true
+		}
+
+	case "mul", "div":
+		// they read rax and rdx as well
+		if instr.operands[0] != reg || reg == "rax" || reg == "rdx" {
+			return AsmInstr{}, false
+		}
+		if isAsmRegister(src) {
+			return asmInstr(instr.op, src), true
+		}
+		if isMemoryOperand(src) {
+			return asmInstr(instr.op, "QWORD "+strings.TrimPrefix(src, "QWORD ")), true
+		}
+	}
+	return AsmInstr{}, false
+}
+
+// rewrites of three instructions. third is at k
+func rewriteTriple(live asmLiveness, k int, first AsmInstr, second AsmInstr, third AsmInstr) ([]AsmLine, bool) {
+	// mov rax, r12
+	// add rax, 5
+	// mov r13, rax
+	// where it can be worked out in r13
+	if first.op != "mov" || third.op != "mov" || (second.op != "add" && second.op != "sub") {
+		return nil, false
+	}
+	scratch := first.operands[0]
+	result := third.operands[0]
+	if !isAsmRegister(scratch) || !isAsmRegister(result) || result == scratch {
+		return nil, false
+	}
+	if second.operands[0] != scratch || third.operands[1] != scratch || live[k][scratch] {
+		return nil, false
+	}
+	if slices.Contains(operandRegisters(second.operands[1]), result) || slices.Contains(operandRegisters(second.operands[1]), scratch) {
+		return nil, false
+	}
+
+	return []AsmLine{
+		asmInstr("mov", result, first.operands[1]),
+		asmInstr(second.op, result, second.operands[1]),
+	}, true
+}
+
+// a mov from src to dest if there's an instruction that can do it
+func asmMove(dest string, src string) (AsmInstr, bool) {
+	if isAsmRegister(dest) {
+		return asmInstr("mov", dest, src), true
+	}
+	if isAsmRegister(src) {
+		return asmInstr("mov", dest, src), true
+	}
+	if isImmediate32(src) {
+		if !strings.HasPrefix(dest, "QWORD ") {
+			dest = "QWORD " + dest
+		}
+		return asmInstr("mov", dest, src), true
+	}
+	return AsmInstr{}, false
+}
+
+func isAsmJump(op string) bool {
+	return slices.Contains([]string{"jmp", "je", "jne", "jz", "jnz", "jb", "jae"}, op)
+}
+
+func isAsmRegister(operand string) bool {
+	return slices.Contains(generalRegisters, operand)
+}
+
+func isImmediate(operand string) bool {
+	_, err := strconv.ParseInt(operand, 10, 64)
+	return err == nil
+}
+
+// immediates that fit in the 32 bits most instructions take
+func isImmediate32(operand string) bool {
+	_, err := strconv.ParseInt(operand, 10, 32)
+	return err == nil
+}
+
+// the registers an instruction reads and writes. instructions it doesn't
+// know, like jumps and calls, are taken to read every register
+func asmInstrRegisters(instr AsmInstr) (reads map[string]bool, writes map[string]bool) {
+	reads = map[string]bool{}
+	writes = map[string]bool{}
+
+	read := func(operand string) {
+		for _, reg := range operandRegisters(operand) {
+			reads[reg] = true
+		}
+	}
+	// registers used to address memory are read
+	write := func(operand string) {
+		if isMemoryOperand(operand) {
+			read(operand)
+			return
+		}
+		for _, reg := range operandRegisters(operand) {
+			writes[reg] = true
+		}
+	}
+
+	switch instr.op {
+	case "mov", "lea":
+		write(instr.operands[0])
+		if instr.op == "lea" {
+			// the address is worked out without reading memory
+			for _, reg := range operandRegisters(instr.operands[1]) {
+				reads[reg] = true
+			}
+		} else {
+			read(instr.operands[1])
+		}
+	case "add", "sub", "neg":
+		read(instr.operands[0])
+		write(instr.operands[0])
+		if len(instr.operands) > 1 {
+			read(instr.operands[1])
+		}
+	case "cmp", "test":
+		read(instr.operands[0])
+		read(instr.operands[1])
+	case "mul", "div":
+		reads["rax"] = true
+		if instr.op == "div" {
+			reads["rdx"] = true
+		}
+		read(instr.operands[0])
+		writes["rax"] = true
+		writes["rdx"] = true
+	case "push":
+		read(instr.operands[0])
+		reads["rsp"] = true
+		writes["rsp"] = true
+	case "pop":
+		write(instr.operands[0])
+		reads["rsp"] = true
+		writes["rsp"] = true
+	case "call":
+		// functions take their arguments on the stack and the runtime in rdi
+		read(instr.operands[0])
+		reads["rsp"] = true
+		reads["rdi"] = true
+		for _, reg := range generalRegisters {
+			if !slices.Contains(calleeSavedRegisters, reg) && reg != "rbp" && reg != "rsp" {
+				writes[reg] = true
+			}
+		}
+	case "syscall":
+		for _, reg := range []string{"rax", "rdi", "rsi", "rdx", "r10", "r8", "r9"} {
+			reads[reg] = true
+		}
+		writes["rax"] = true
+		writes["rcx"] = true
+		writes["r11"] = true
+	case "ret":
+		// the caller gets back the registers it expects to be kept
+		reads["rsp"] = true
+		reads["rbp"] = true
+		for _, reg := range calleeSavedRegisters {
+			reads[reg] = true
+		}
+	case "dq", "jmp", "je", "jne", "jz", "jnz", "jb", "jae":
+		// where jumps go is followed by findAsmLiveness
+	default:
+		for _, reg := range generalRegisters {
+			reads[reg] = true
+		}
+	}
+	return reads, writes
+}
+
+// the registers that might be read after each line before they're written
+type asmLiveness []map[string]bool
+
+func findAsmLiveness(lines []AsmLine) asmLiveness {
+	labels := map[string]int{}
+	for i, line := range lines {
+		if label, ok := line.(AsmLabel); ok {
+			labels[label.name] = i
+		}
+	}
+
+	// the lines that can run after each one. jumps out of the
+	// function are to the runtime errors which don't read registers
+	successors := make([][]int, len(lines))
+	for i, rawLine := range lines {
+		next := []int{}
+		if i+1 < len(lines) {
+			next = append(next, i+1)
+		}
+
+		instr, ok := rawLine.(AsmInstr)
+		if !ok {
+			successors[i] = next
+			continue
+		}
+		switch instr.op {
+		case "jmp":
+			next = []int{}
+			fallthrough
+		case "je", "jne", "jz", "jnz", "jb", "jae":
+			if target, ok := labels[instr.operands[0]]; ok {
+				next = append(next, target)
+			}
+		case "ret":
+			next = []int{}
+		}
+		successors[i] = next
+	}
+
+	reads := make([]map[string]bool, len(lines))
+	writes := make([]map[string]bool, len(lines))
+	for i, rawLine := range lines {
+		switch line := rawLine.(type) {
+		case AsmInstr:
+			reads[i], writes[i] = asmInstrRegisters(line)
+		case AsmRaw:
+			reads[i], writes[i] = map[string]bool{}, map[string]bool{}
+			for _, reg := range generalRegisters {
+				reads[i][reg] = true
+			}
+		case AsmLabel, AsmComment:
+			reads[i], writes[i] = map[string]bool{}, map[string]bool{}
+		default:
+			panic(fmt.Errorf("peephole error: unknown line: %T", rawLine))
+		}
+	}
+
+	liveOut := make(asmLiveness, len(lines))
+	liveIn := make([]map[string]bool, len(lines))
+	for i := range lines {
+		liveOut[i] = map[string]bool{}
+		liveIn[i] = map[string]bool{}
+	}
+
+	// registers only ever become live so a change is a bigger set
+	for changed := true; changed; {
+		changed = false
+		for i := len(lines) - 1; i >= 0; i-- {
+			for _, s := range successors[i] {
+				for reg := range liveIn[s] {
+					liveOut[i][reg] = true
+				}
+			}
+
+			before := len(liveIn[i])
+			for reg := range reads[i] {
+				liveIn[i][reg] = true
+			}
+			for reg := range liveOut[i] {
+				if !writes[i][reg] {
+					liveIn[i][reg] = true
+				}
+			}
+			if len(liveIn[i]) != before {
+				changed = true
+			}
+		}
+	}
+	return liveOut
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+// assembly written one line each, as labels ending in ':',
+// comments starting with ';' or instructions
+func parseAsmLines(text string) []AsmLine {
+	lines := []AsmLine{}
+	for _, line := range strings.Split(strings.TrimSpace(text), "\n") {
+		line = strings.TrimSpace(line)
+		if name, ok := strings.CutSuffix(line, ":"); ok {
+			lines = append(lines, AsmLabel{name: name})
+		} else if comment, ok := strings.CutPrefix(line, "; "); ok {
+			lines = append(lines, AsmComment{text: comment})
+		} else {
+			op, operands, _ := strings.Cut(line, " ")
+			if operands == "" {
+				lines = append(lines, asmInstr(op))
+			} else {
+				lines = append(lines, asmInstr(op, strings.Split(operands, ", ")...))
+			}
+		}
+	}
+	return lines
+}
+
+func TestPeephole(t *testing.T) {
+	tests := []struct {
+		name string
+		asm  string
+		want string
+	}{
+		{"move to itself", "mov rax, rax\nret", "ret"},
+		{"add nothing", "add rsp, 0\nret", "ret"},
+		{"jump to next", "jmp next\n; comment\nnext:\nret", "; comment\nnext:\nret"},
+		{"push pop", "push rax\npop rbx\nret", "mov rbx, rax\nret"},
+		{"push pop memory", "push QWORD [rbp - 8]\npop rbx\nret", "mov rbx, [rbp - 8]\nret"},
+		{"store load", "mov [rbp - 8], rax\nmov rax, [rbp - 8]\nret", "mov [rbp - 8], rax\nret"},
+		{"overwritten", "mov rax, 1\nmov rax, 2\nret", "mov rax, 2\nret"},
+		{"forward copy", "mov rsi, rbx\nsub r9, rsi\nret", "sub r9, rbx\nret"},
+		{"forward past others", "mov rsi, rbx\nmov r8, r12\nsub r9, rsi\nret", "mov r8, r12\nsub r9, rbx\nret"},
+		{"copy still needed", "mov rsi, rbx\nsub r9, rsi\npush rsi\nret", "mov rsi, rbx\nsub r9, rsi\npush rsi\nret"},
+		{"worked out in place", "mov rax, r12\nadd rax, 5\nmov r13, rax\nret", "mov r13, r12\nadd r13, 5\nret"},
+		{"not over a label", "mov rax, 1\nagain:\nmov rax, 2\npush rax\njmp again", "mov rax, 1\nagain:\npush 2\njmp again"},
+		{"every rewrite in a pass", "mov rax, rax\npush rax\npop rbx\nadd rsp, 0\nret", "mov rbx, rax\nret"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got := asmLinesString(peephole(parseAsmLines(test.asm)))
+			want := asmLinesString(parseAsmLines(test.want))
+			if got != want {
+				t.Errorf("got\n%swant\n%s", got, want)
+			}
+		})
+	}
+}
+
+// rewrites that only hold on the way to the line after
+// them mustn't be made past where control can go elsewhere
+func TestPeepholeControlFlow(t *testing.T) {
+	tests := []struct {
+		name string
+		asm  string
+	}{
+		{"copy past conditional jump", "mov rsi, rax\ncmp rax, 1\njb skip\nsub r9, rsi\nret\nskip:\npush rsi\nret"},
+		{"copy past jump", "mov rsi, rax\njmp skip\nsub r9, rsi\nskip:\npush rsi\nret"},
+		{"copy past label", "mov rsi, rbx\nloop:\nsub r9, rsi\njmp loop"},
+		{"triple live after", "mov rax, r12\nadd rax, 5\nmov r13, rax\npush rax\nret"},
+		{"copy read after a loop", "mov rsi, rbx\nloop:\ncmp r8, 0\nje done\nsub r8, 1\njmp loop\ndone:\npush rsi\nret"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got := asmLinesString(peephole(parseAsmLines(test.asm)))
+			want := asmLinesString(parseAsmLines(test.asm))
+			if got != want {
+				t.Errorf("got\n%swant it unchanged\n%s", got, want)
+			}
+		})
+	}
+}
+
+func TestAsmLiveness(t *testing.T) {
+	lines := parseAsmLines("mov rsi, 1\ncmp rax, 0\nje out\nmov rsi, 2\nout:\npush rsi\nret")
+	live := findAsmLiveness(lines)
+
+	// the first rsi is read if the jump is taken
+	if !live[0]["rsi"] || !live[2]["rsi"] {
+		t.Errorf("rsi isn't live up to the jump: %v, %v", live[0], live[2])
+	}
+	if !live[0]["rax"] || live[1]["rax"] {
+		t.Errorf("rax should only be live until it's compared: %v, %v", live[0], live[1])
+	}
+	if live[5]["rsi"] {
+		t.Errorf("rsi is live after its last read: %v", live[5])
+	}
+}
@@ -0,0 +1,426 @@
+package main
+
+import (
+	"cmp"
+	"fmt"
+	"slices"
+)
+
+/*
+	Register allocation keeps temporaries and locals in registers instead
+	of going through the frame for every use. Locals whose address is
+	taken or which inline assembly uses have to be in memory so they stay
+	in the frame.
+
+	It's a linear scan. The instructions are numbered in the order they're
+	generated and each value is live from the first to the last of them
+	it's live at, found with a liveness analysis so a value used round a
+	loop is live for all of it. Values are given registers in order of
+	where they start and when there isn't one free the value that's live
+	for longest is spilled to the frame.
+
+	Calls, syscalls and the runtime can change any register that isn't
+	callee saved so values live across them only go in those, which a
+	function saves in its frame before using. Inline assembly can change
+	any register so nothing is kept in one across it, and a function with
+	inline assembly saves all the callee saved registers for its caller.
+*/
+
+var (
+	callerSavedRegisters = []string{"rsi", "r8", "r9", "r10"}
+	calleeSavedRegisters = []string{"rbx", "r12", "r13", "r14", "r15"}
+)
+
+// a temp or a local. only one of them is set
+type irVar struct {
+	temp  IRTemp
+	local *IRLocal
+}
+
+func tempVar(temp IRTemp) irVar {
+	return irVar{temp: temp}
+}
+
+func localVar(local *IRLocal) irVar {
+	return irVar{local: local}
+}
+
+type allocation struct {
+	registers map[irVar]string
+
+	// the frame slots, counting down from rbp, of the locals and
+	// temps that aren't in registers. parameters not in registers
+	// stay where they were passed so don't have slots
+	slots map[irVar]int
+	// the callee saved registers the function uses and the slots they're saved in
+	saved      []string
+	savedSlots map[string]int
+
+	// promoted parameters that are read before they're assigned
+	// so have to be loaded into their register when the function starts
+	paramLoads []*IRLocal
+
+	frameSize int
+}
+
+type liveInterval struct {
+	value      irVar
+	start, end int
+
+	// crosses something that can change caller saved registers
+	crossesCall bool
+	// crosses inline assembly which can change any register
+	crossesAsm bool
+
+	register string
+}
+
+type allocator struct {
+	function *IRFunction
+
+	// locals that can be kept in registers
+	promotable map[*IRLocal]bool
+
+	liveIn  map[*IRBlock]map[irVar]bool
+	liveOut map[*IRBlock]map[irVar]bool
+}
+
+func allocateRegisters(function *IRFunction) allocation {
+	a := allocator{
+		function:   function,
+		promotable: map[*IRLocal]bool{},
+		liveIn:     map[*IRBlock]map[irVar]bool{},
+		liveOut:    map[*IRBlock]map[irVar]bool{},
+	}
+
+	hasAsm := false
+	for _, local := range append(slices.Clone(function.params), function.locals...) {
+		a.promotable[local] = true
+	}
+	for _, b := range function.blocks {
+		for _, rawInstr := range b.instrs {
+			switch instr := rawInstr.(type) {
+			case IRAddressOf:
+				a.promotable[instr.local] = false
+			case IRAsm:
+				hasAsm = true
+				for _, local := range instr.operands {
+					a.promotable[local] = false
+				}
+			}
+		}
+	}
+
+	a.findLiveness()
+	intervals := a.findIntervals()
+	a.scan(intervals)
+
+	result := allocation{
+		registers:  map[irVar]string{},
+		slots:      map[irVar]int{},
+		saved:      []string{},
+		savedSlots: map[string]int{},
+		paramLoads: []*IRLocal{},
+	}
+	for _, i := range intervals {
+		if i.register != "" {
+			result.registers[i.value] = i.register
+		}
+	}
+
+	slotCount := 0
+	for _, local := range function.locals {
+		if _, inRegister := result.registers[localVar(local)]; !inRegister {
+			result.slots[localVar(local)] = slotCount
+			slotCount++
+		}
+	}
+
+	// the top level code has no caller to save registers for
+	if function.function != nil {
+		for _, reg := range calleeSavedRegisters {
+			if hasAsm || slices.ContainsFunc(intervals, func(i *liveInterval) bool { return i.register == reg }) {
+				result.saved = append(result.saved, reg)
+				result.savedSlots[reg] = slotCount
+				slotCount++
+			}
+		}
+	}
+
+	for temp := range function.tempCount {
+		if _, inRegister := result.registers[tempVar(IRTemp(temp))]; !inRegister {
+			result.slots[tempVar(IRTemp(temp))] = slotCount
+			slotCount++
+		}
+	}
+
+	if len(function.blocks) > 0 {
+		for _, p := range function.params {
+			_, inRegister := result.registers[localVar(p)]
+			if inRegister && a.liveIn[function.blocks[0]][localVar(p)] {
+				result.paramLoads = append(result.paramLoads, p)
+			}
+		}
+	}
+
+	result.frameSize = slotCount * 8
+	return result
+}
+
+// the temps and promotable locals an instruction reads and assigns
+func (a *allocator) instrVars(rawInstr IRInstr) (uses []irVar, defs []irVar) {
+	uses = a.valueVars(instrUses(rawInstr))
+	defs = []irVar{}
+	if dest := instrDest(rawInstr); dest.HasValue() {
+		defs = append(defs, tempVar(dest.MustGetValue()))
+	}
+
+	switch instr := rawInstr.(type) {
+	case IRLoad:
+		if a.promotable[instr.local] {
+			uses = append(uses, localVar(instr.local))
+		}
+	case IRStore:
+		if a.promotable[instr.local] {
+			defs = append(defs, localVar(instr.local))
+		}
+	}
+	return uses, defs
+}
+
+func (a *allocator) valueVars(values []IRValue) []irVar {
+	vars := []irVar{}
+	for _, v := range values {
+		if temp, ok := v.(IRTemp); ok {
+			vars = append(vars, tempVar(temp))
+		}
+	}
+	return vars
+}
+
+// the values live at the start and end of each block, going
+// backwards from where they're used until nothing changes
+func (a *allocator) findLiveness() {
+	uses := map[*IRBlock]map[irVar]bool{}
+	defs := map[*IRBlock]map[irVar]bool{}
+
+	for _, b := range a.function.blocks {
+		uses[b] = map[irVar]bool{}
+		defs[b] = map[irVar]bool{}
+		a.liveIn[b] = map[irVar]bool{}
+		a.liveOut[b] = map[irVar]bool{}
+
+		for _, instr := range b.instrs {
+			instrUses, instrDefs := a.instrVars(instr)
+			for _, v := range instrUses {
+				if !defs[b][v] {
+					uses[b][v] = true
+				}
+			}
+			for _, v := range instrDefs {
+				defs[b][v] = true
+			}
+		}
+		for _, v := range a.valueVars(terminatorUses(b.terminator)) {
+			if !defs[b][v] {
+				uses[b][v] = true
+			}
+		}
+	}
+
+	for changed := true; changed; {
+		changed = false
+		for i := len(a.function.blocks) - 1; i >= 0; i-- {
+			b := a.function.blocks[i]
+
+			for _, s := range b.successors {
+				for v := range a.liveIn[s] {
+					a.liveOut[b][v] = true
+				}
+			}
+
+			// values only ever get added so a change is a bigger set
+			before := len(a.liveIn[b])
+			for v := range uses[b] {
+				a.liveIn[b][v] = true
+			}
+			for v := range a.liveOut[b] {
+				if !defs[b][v] {
+					a.liveIn[b][v] = true
+				}
+			}
+			if len(a.liveIn[b]) != before {
+				changed = true
+			}
+		}
+	}
+}
+
+// the interval each value is live over in the order they start
+func (a *allocator) findIntervals() []*liveInterval {
+	intervals := map[irVar]*liveInterval{}
+	extend := func(v irVar, position int) {
+		interval, ok := intervals[v]
+		if !ok {
+			intervals[v] = &liveInterval{value: v, start: position, end: position}
+			return
+		}
+		interval.start = min(interval.start, position)
+		interval.end = max(interval.end, position)
+	}
+
+	callPositions := []int{}
+	asmPositions := []int{}
+
+	position := 0
+	for _, b := range a.function.blocks {
+		blockStart := position
+
+		for _, instr := range b.instrs {
+			uses, defs := a.instrVars(instr)
+			for _, v := range append(uses, defs...) {
+				extend(v, position)
+			}
+
+			switch instr.(type) {
+			case IRCall, IRCallIndirect, IRSyscall, IRRuntimeCall:
+				callPositions = append(callPositions, position)
+			case IRAsm:
+				asmPositions = append(asmPositions, position)
+			}
+			position++
+		}
+
+		for _, v := range a.valueVars(terminatorUses(b.terminator)) {
+			extend(v, position)
+		}
+		blockEnd := position
+		position++
+
+		for v := range a.liveIn[b] {
+			extend(v, blockStart)
+		}
+		for v := range a.liveOut[b] {
+			extend(v, blockEnd)
+		}
+	}
+
+	// parameters are assigned before the function starts so they
+	// cross a call that's its first instruction
+	if len(a.function.blocks) > 0 {
+		for v := range a.liveIn[a.function.blocks[0]] {
+			extend(v, -1)
+		}
+	}
+
+	sorted := []*liveInterval{}
+	for _, interval := range intervals {
+		// a value used or assigned at a call is read before it or assigned after it
+		crosses := func(p int) bool { return interval.start < p && p < interval.end }
+		interval.crossesCall = slices.ContainsFunc(callPositions, crosses)
+		interval.crossesAsm = slices.ContainsFunc(asmPositions, crosses)
+		sorted = append(sorted, interval)
+	}
+
+	slices.SortFunc(sorted, func(x *liveInterval, y *liveInterval) int {
+		return cmp.Or(
+			cmp.Compare(x.start, y.start),
+			cmp.Compare(x.end, y.end),
+			compareIRVars(x.value, y.value),
+		)
+	})
+	return sorted
+}
+
+// locals before temps, each in the order they were made
+func compareIRVars(x irVar, y irVar) int {
+	if (x.local == nil) != (y.local == nil) {
+		if x.local != nil {
+			return -1
+		}
+		return 1
+	}
+	if x.local == nil {
+		return cmp.Compare(x.temp, y.temp)
+	}
+	return cmp.Or(
+		-cmp.Compare(boolToInt(x.local.isParameter), boolToInt(y.local.isParameter)),
+		cmp.Compare(x.local.index, y.local.index),
+	)
+}
+
+func boolToInt(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+func (a *allocator) scan(intervals []*liveInterval) {
+	active := []*liveInterval{}
+
+	for _, current := range intervals {
+		// values live up to the start of this one still need their register
+		active = slices.DeleteFunc(active, func(i *liveInterval) bool { return i.end < current.start })
+
+		if current.crossesAsm {
+			continue
+		}
+		allowed := append(slices.Clone(callerSavedRegisters), calleeSavedRegisters...)
+		if current.crossesCall {
+			allowed = calleeSavedRegisters
+		}
+
+		free := ""
+		for _, reg := range allowed {
+			if !slices.ContainsFunc(active, func(i *liveInterval) bool { return i.register == reg }) {
+				free = reg
+				break
+			}
+		}
+		if free != "" {
+			current.register = free
+			active = append(active, current)
+			continue
+		}
+
+		// the value that's live for longest is the one spilled
+		var victim *liveInterval
+		for _, i := range active {
+			if slices.Contains(allowed, i.register) && (victim == nil || i.end > victim.end) {
+				victim = i
+			}
+		}
+		if victim != nil && victim.end > current.end {
+			current.register = victim.register
+			victim.register = ""
+			active = slices.DeleteFunc(active, func(i *liveInterval) bool { return i == victim })
+			active = append(active, current)
+		}
+	}
+}
+
+// E.G. "%3" or "i"
+func (f IRFunction) varName(v irVar) string {
+	if v.local != nil {
+		return f.localName(v.local)
+	}
+	return irValueString(v.temp)
+}
+
+// which register each local is in, for the comments in the assembly
+func (a allocation) describe(function *IRFunction) []string {
+	vars := []irVar{}
+	for v := range a.registers {
+		if v.local != nil {
+			vars = append(vars, v)
+		}
+	}
+	slices.SortFunc(vars, compareIRVars)
+
+	descriptions := []string{}
+	for _, v := range vars {
+		descriptions = append(descriptions, fmt.Sprintf("%s: %s", function.varName(v), a.registers[v]))
+	}
+	return descriptions
+}
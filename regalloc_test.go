@@ -0,0 +1,79 @@
+package main
+
+import (
+	"slices"
+	"testing"
+)
+
+// values live across a call can only be kept in callee saved registers
+func TestAllocateAcrossCalls(t *testing.T) {
+	tests := []struct {
+		name   string
+		source string
+	}{
+		{"call first", "func 0 g() {}\nfunc 1 f(n) {\n\tg();\n\treturn n * 3;\n}\nf(1);"},
+		{"call later", "func 0 g() {}\nfunc 1 f(n) {\n\tvar x = n + 1;\n\tg();\n\treturn x + n;\n}\nf(1);"},
+		{"syscall", "func 1 f(n) {\n\tsyscall(39);\n\treturn n;\n}\nf(1);"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ir := lowerSource(t, test.source)
+			f := findIRFunction(t, ir, "f")
+			a := allocateRegisters(f)
+
+			for _, local := range append(f.params, f.locals...) {
+				if reg, ok := a.registers[localVar(local)]; ok && slices.Contains(callerSavedRegisters, reg) {
+					t.Errorf("%s is in %s which the call can change", local.name, reg)
+				}
+			}
+		})
+	}
+}
+
+func TestAllocateInMemory(t *testing.T) {
+	tests := []struct {
+		name   string
+		source string
+	}{
+		{"address taken", "func 1 f(n) {\n\tvar x = n;\n\tvar p = &x;\n\treturn *p;\n}\nf(1);"},
+		{"asm operand", "func 1 f(n) {\n\tvar x = n;\n\tasm { add %x, 1 }\n\treturn x;\n}\nf(1);"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ir := lowerSource(t, test.source)
+			f := findIRFunction(t, ir, "f")
+			a := allocateRegisters(f)
+
+			for _, local := range f.locals {
+				if local.name != "x" {
+					continue
+				}
+				if reg, ok := a.registers[localVar(local)]; ok {
+					t.Errorf("x is in %s but has to be in memory", reg)
+				}
+				if _, ok := a.slots[localVar(local)]; !ok {
+					t.Error("x has no frame slot")
+				}
+			}
+		})
+	}
+}
+
+func TestAllocateDistinctRegisters(t *testing.T) {
+	// every value is live at the end so none can share a register
+	ir := lowerSource(t, "func 1 f(a, b) {\n\tvar c = a + b;\n\tvar d = a * b;\n\tvar e = c - d;\n\treturn a + b + c + d + e;\n}\nf(1, 2);")
+	f := findIRFunction(t, ir, "f")
+	a := allocateRegisters(f)
+
+	used := map[string]string{}
+	for _, local := range append(f.params, f.locals...) {
+		reg, ok := a.registers[localVar(local)]
+		if !ok {
+			continue
+		}
+		if other, taken := used[reg]; taken {
+			t.Errorf("%s and %s are both in %s", other, local.name, reg)
+		}
+		used[reg] = local.name
+	}
+}
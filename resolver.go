@@ -0,0 +1,873 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	opt "github.com/moltenwolfcub/moltenCompiler/optional"
+)
+
+/*
+	The resolver runs between parsing and code generation. It works out
+	what every identifier refers to, checking the program makes sense as
+	it goes, and gives back the program with each use of a name annotated
+	with the Symbol it was bound to.
+
+	Every function is known before any code is resolved so functions can
+	be called before they are defined. Variables live in nested scopes and
+	can't shadow a variable from an enclosing scope. A function body can
+	only see its own parameters and locals, not the top level variables.
+*/
+
+// what a name refers to once resolved.
+// one of *Variable, *Function, SyscallInfo or Builtin
+type Symbol interface {
+	IsSymbol()
+}
+
+func (*Variable) IsSymbol()   {}
+func (*Function) IsSymbol()   {}
+func (SyscallInfo) IsSymbol() {}
+func (Builtin) IsSymbol()     {}
+
+type Resolver struct {
+	program NodeProg
+
+	functions []*Function
+
+	// the variables declared in each scope, innermost last
+	scopes [][]*Variable
+
+	// nil in top level code
+	currentFunction *Function
+	loopDepth       int
+
+	errors ErrorList
+}
+
+func NewResolver(prog NodeProg) Resolver {
+	return Resolver{
+		program: prog,
+
+		functions: []*Function{},
+		scopes:    [][]*Variable{},
+	}
+}
+
+func (r *Resolver) Resolve() (NodeProg, error) {
+	prog := r.program
+
+	r.declareFunctions()
+
+	prog.stmts = make([]NodeStmt, len(r.program.stmts))
+	functionIndex := 0
+	for i, stmt := range r.program.stmts {
+		funcStmt, ok := stmt.(NodeStmtFunctionDefinition)
+		if !ok {
+			continue
+		}
+		prog.stmts[i] = r.resolveFuncDefinition(funcStmt, r.functions[functionIndex])
+		functionIndex++
+	}
+
+	// top level code is in the outermost scope
+	r.beginScope()
+
+	prog.init = r.resolveStmts(r.program.init)
+
+	for i, stmt := range r.program.stmts {
+		if _, ok := stmt.(NodeStmtFunctionDefinition); ok {
+			continue
+		}
+		resolved, err := r.resolveStmt(stmt)
+		if err != nil {
+			r.errors.Add(err)
+			continue
+		}
+		prog.stmts[i] = resolved
+	}
+
+	r.endScope()
+
+	mainFunc, hasMain, err := r.findMain()
+	r.errors.Add(err)
+	if hasMain {
+		prog.main = opt.ToOptional(mainFunc)
+	}
+
+	// the program is only usable when every error has been fixed
+	// but they're all reported at once
+	if err := r.errors.Err(); err != nil {
+		return NodeProg{}, err
+	}
+	return prog, nil
+}
+
+// creates the symbol for every function up front
+func (r *Resolver) declareFunctions() {
+	for _, stmt := range r.program.stmts {
+		funcStmt, ok := stmt.(NodeStmtFunctionDefinition)
+		if !ok {
+			continue
+		}
+
+		returnCount, err := strconv.Atoi(funcStmt.returns)
+		if err != nil {
+			r.errors.Add(funcStmt.ident.PositionedError(codeBadReturnCount, fmt.Sprintf("invalid return count: %s", funcStmt.returns)))
+		}
+
+		function := &Function{
+			name:        funcStmt.ident.value.MustGetValue(),
+			ident:       funcStmt.ident,
+			parameters:  len(funcStmt.params),
+			returnCount: returnCount,
+			variadic:    funcStmt.variadic.HasValue(),
+			inline:      funcStmt.inline.HasValue(),
+			file:        funcStmt.ident.lineInfo.File,
+			namespace:   r.program.files[funcStmt.ident.lineInfo.File].namespace,
+		}
+		if funcStmt.variadic.HasValue() {
+			function.variadicName = funcStmt.variadic.MustGetValue().value.MustGetValue()
+		}
+
+		for _, f := range r.functions {
+			if f.label() == function.label() {
+				r.errors.Add(funcStmt.ident.PositionedError(codeDuplicateFunction, fmt.Sprintf("function identifier already used: %v", function.name)).
+					WithLabel(f.ident.span(), "previous definition here").
+					WithNote("functions can be overloaded by their number of parameters"))
+				break
+			}
+		}
+		// duplicates are still kept so their bodies are checked
+		r.functions = append(r.functions, function)
+	}
+}
+
+// the main file can have a `main` function as its entry point instead of top
+// level code. it can take (argc, argv) or (argc, argv, envp) and if it
+// returns a value that is the program's exit code.
+func (r *Resolver) findMain() (*Function, bool, error) {
+	var mainFunc *Function
+	for _, f := range r.functions {
+		if f.name != "main" || f.namespace != "" {
+			continue
+		}
+		if mainFunc != nil {
+			return nil, false, f.ident.PositionedError(codeBadMain, "main function can only be defined once").WithLabel(mainFunc.ident.span(), "first defined here")
+		}
+		mainFunc = f
+	}
+	if mainFunc == nil {
+		return nil, false, nil
+	}
+
+	for _, stmt := range r.program.stmts {
+		if _, ok := stmt.(NodeStmtFunctionDefinition); !ok {
+			return nil, false, mainFunc.ident.PositionedError(codeBadMain, "can't have top level statements as well as a main function").
+				WithHelp("move the top level code into main")
+		}
+	}
+
+	if mainFunc.variadic || (mainFunc.parameters != 0 && mainFunc.parameters != 2 && mainFunc.parameters != 3) {
+		return nil, false, mainFunc.ident.PositionedError(codeBadMain, "main function must take no parameters, (argc, argv) or (argc, argv, envp)")
+	}
+	if mainFunc.returnCount > 1 {
+		return nil, false, mainFunc.ident.PositionedError(codeBadMain, "main function can return at most 1 value")
+	}
+
+	return mainFunc, true, nil
+}
+
+func (r *Resolver) resolveFuncDefinition(stmt NodeStmtFunctionDefinition, function *Function) NodeStmtFunctionDefinition {
+	// functions can't see the variables of the code around them
+	outerScopes := r.scopes
+	r.scopes = [][]*Variable{}
+	r.currentFunction = function
+	r.beginScope()
+
+	stmt.function = function
+	stmt.parameters = []*Variable{}
+	for _, p := range stmt.params {
+		v, err := r.declareVariable(p, true)
+		r.errors.Add(err)
+		stmt.parameters = append(stmt.parameters, v)
+	}
+
+	// the body shares the parameters' scope
+	errorCount := len(r.errors)
+	stmt.body.stmts = r.resolveStmts(stmt.body.stmts)
+
+	// statements with errors are left out so
+	// the body is only checked if it had none
+	if len(r.errors) == errorCount {
+		if function.returnCount > 0 && fallsThrough(stmt.body.stmts) {
+			r.errors.Add(stmt.body.closeBrace.PositionedError(codeMissingReturn, fmt.Sprintf("missing return at the end of function '%s'", function.name)).
+				WithLabel(function.ident.span(), "declared to return "+plural(function.returnCount, "value")+" here").
+				WithNote("every path through a function that returns values has to end with a `return`"))
+		}
+		r.errors.Add(checkEscapes(stmt))
+	}
+
+	r.endScope()
+	r.currentFunction = nil
+	r.scopes = outerScopes
+
+	return stmt
+}
+
+// statements that fail to resolve are left out after their error is
+// recorded so the rest of the statements are still checked
+func (r *Resolver) resolveStmts(stmts []NodeStmt) []NodeStmt {
+	resolved := []NodeStmt{}
+	for _, stmt := range stmts {
+		s, err := r.resolveStmt(stmt)
+		if err != nil {
+			r.errors.Add(err)
+			continue
+		}
+		resolved = append(resolved, s)
+	}
+	return resolved
+}
+
+func (r *Resolver) resolveStmt(rawStmt NodeStmt) (NodeStmt, error) {
+	switch stmt := rawStmt.(type) {
+	case NodeStmtVarDeclare:
+		var exprErr error
+		if stmt.expr.HasValue() {
+			var expr NodeExpr
+			expr, exprErr = r.resolveExpr(stmt.expr.MustGetValue())
+			stmt.expr = opt.ToOptional(expr)
+		}
+
+		// declared even if its value has an error so later
+		// uses of it don't give undefined variable errors
+		variable, err := r.declareVariable(stmt.ident, false)
+		if exprErr != nil {
+			return nil, exprErr
+		}
+		if err != nil {
+			return nil, err
+		}
+		stmt.variable = variable
+		return stmt, nil
+
+	case NodeStmtVarAssign:
+		variable, exists := r.findVariable(stmt.ident.value.MustGetValue())
+		if !exists {
+			return nil, r.undefinedVariable(stmt.ident, fmt.Sprintf("undefined variable: '%s'", stmt.ident.value.MustGetValue()))
+		}
+		stmt.variable = variable
+
+		expr, err := r.resolveExpr(stmt.expr)
+		if err != nil {
+			return nil, err
+		}
+		stmt.expr = expr
+		return stmt, nil
+
+	case NodeStmtPointerAssign:
+		variable, exists := r.findVariable(stmt.ident.value.MustGetValue())
+		if !exists {
+			return nil, r.undefinedVariable(stmt.ident, fmt.Sprintf("undefined variable: '%s'", stmt.ident.value.MustGetValue()))
+		}
+		stmt.variable = variable
+
+		expr, err := r.resolveExpr(stmt.expr)
+		if err != nil {
+			return nil, err
+		}
+		stmt.expr = expr
+		return stmt, nil
+
+	case NodeScope:
+		return r.resolveScope(stmt), nil
+
+	case NodeStmtIf:
+		return r.resolveIf(stmt), nil
+
+	case NodeStmtWhile:
+		expr, err := r.resolveExpr(stmt.expr)
+		r.errors.Add(err)
+		stmt.expr = expr
+
+		r.loopDepth++
+		stmt.scope = r.resolveScope(stmt.scope)
+		r.loopDepth--
+		return stmt, nil
+
+	case NodeStmtBreak:
+		if r.loopDepth == 0 {
+			return nil, stmt._break.PositionedError(codeOutsideLoop, "can't break when not in a loop")
+		}
+		return stmt, nil
+
+	case NodeStmtContinue:
+		if r.loopDepth == 0 {
+			return nil, stmt._continue.PositionedError(codeOutsideLoop, "can't continue when not in a loop")
+		}
+		return stmt, nil
+
+	case NodeStmtFunctionDefinition:
+		// top level functions are resolved before everything else
+		return nil, stmt.ident.PositionedError(codeNestedFunction, "functions can only be defined at the top level of a file")
+
+	case NodeStmtImport:
+		return nil, stmt._import.PositionedError(codeNestedImport, "imports must be at the top level of a file")
+
+	case NodeFunctionCall:
+		return r.resolveFuncCall(stmt, false)
+
+	case NodeIndirectCall:
+		return r.resolveIndirectCall(stmt)
+
+	case NodeStmtReturn:
+		if r.currentFunction == nil {
+			// returning from top level code exits the program
+			if len(stmt.returns) > 1 {
+				return nil, stmt._return.PositionedError(codeTopLevelReturn, "can only return one value, the exit code, from top level code")
+			}
+		} else if len(stmt.returns) != r.currentFunction.returnCount {
+			return nil, stmt._return.PositionedError(codeReturnCount, fmt.Sprintf("incorrect number of values returned. Expected %v, Found %v", r.currentFunction.returnCount, len(stmt.returns))).
+				WithLabel(r.currentFunction.ident.span(), "declared to return "+plural(r.currentFunction.returnCount, "value")+" here")
+		}
+		stmt.function = r.currentFunction
+
+		returns, err := r.resolveExprs(stmt.returns)
+		if err != nil {
+			return nil, err
+		}
+		stmt.returns = returns
+		return stmt, nil
+
+	case NodeStmtSyscall:
+		arguments, err := r.resolveSyscall(stmt.arguments, stmt.syscall)
+		if err != nil {
+			return nil, err
+		}
+		stmt.arguments = arguments
+		return stmt, nil
+
+	case NodeStmtAsm:
+		return r.resolveAsm(stmt)
+
+	default:
+		panic(fmt.Errorf("resolver error: don't know how to resolve statement: %T", rawStmt))
+	}
+}
+
+func (r *Resolver) resolveScope(scope NodeScope) NodeScope {
+	r.beginScope()
+	scope.stmts = r.resolveStmts(scope.stmts)
+	r.endScope()
+
+	return scope
+}
+
+func (r *Resolver) resolveIf(stmt NodeStmtIf) NodeStmtIf {
+	// the branches are checked even if the condition has an error
+	expr, err := r.resolveExpr(stmt.expr)
+	r.errors.Add(err)
+	stmt.expr = expr
+
+	stmt.scope = r.resolveScope(stmt.scope)
+
+	if !stmt.elseBranch.HasValue() {
+		return stmt
+	}
+
+	switch _else := stmt.elseBranch.MustGetValue().(type) {
+	case NodeElseScope:
+		_else.scope = r.resolveScope(_else.scope)
+		stmt.elseBranch = opt.ToOptional(NodeElse(_else))
+	case NodeElseElif:
+		_else.ifStmt = r.resolveIf(_else.ifStmt)
+		stmt.elseBranch = opt.ToOptional(NodeElse(_else))
+	default:
+		panic(fmt.Errorf("resolver error: don't know how to resolve else branch: %T", _else))
+	}
+	return stmt
+}
+
+func (r *Resolver) resolveExprs(exprs []NodeExpr) ([]NodeExpr, error) {
+	resolved := []NodeExpr{}
+	for _, e := range exprs {
+		expr, err := r.resolveExpr(e)
+		if err != nil {
+			return nil, err
+		}
+		resolved = append(resolved, expr)
+	}
+	return resolved, nil
+}
+
+func (r *Resolver) resolveExpr(rawExpr NodeExpr) (NodeExpr, error) {
+	switch expr := rawExpr.(type) {
+	case NodeTerm:
+		return r.resolveTerm(expr)
+	case NodeBinExpr:
+		return r.resolveBinExpr(expr)
+	default:
+		panic(fmt.Errorf("resolver error: don't know how to resolve expression: %T", rawExpr))
+	}
+}
+
+func (r *Resolver) resolveBinExpr(rawBinExpr NodeBinExpr) (NodeBinExpr, error) {
+	resolveSides := func(left NodeExpr, right NodeExpr) (NodeExpr, NodeExpr, error) {
+		left, err := r.resolveExpr(left)
+		if err != nil {
+			return nil, nil, err
+		}
+		right, err = r.resolveExpr(right)
+		if err != nil {
+			return nil, nil, err
+		}
+		return left, right, nil
+	}
+
+	var err error
+	switch binExpr := rawBinExpr.(type) {
+	case NodeBinExprAdd:
+		binExpr.left, binExpr.right, err = resolveSides(binExpr.left, binExpr.right)
+		return binExpr, err
+	case NodeBinExprSubtract:
+		binExpr.left, binExpr.right, err = resolveSides(binExpr.left, binExpr.right)
+		return binExpr, err
+	case NodeBinExprMultiply:
+		binExpr.left, binExpr.right, err = resolveSides(binExpr.left, binExpr.right)
+		return binExpr, err
+	case NodeBinExprDivide:
+		binExpr.left, binExpr.right, err = resolveSides(binExpr.left, binExpr.right)
+		return binExpr, err
+	case NodeBinExprModulo:
+		binExpr.left, binExpr.right, err = resolveSides(binExpr.left, binExpr.right)
+		return binExpr, err
+	default:
+		panic(fmt.Errorf("resolver error: don't know how to resolve binary expression: %T", rawBinExpr))
+	}
+}
+
+func (r *Resolver) resolveTerm(rawTerm NodeTerm) (NodeTerm, error) {
+	switch term := rawTerm.(type) {
+	case NodeTermIntLiteral:
+		return term, nil
+
+	case NodeTermIdentifier:
+		name := term.identifier.value.MustGetValue()
+
+		if variable, exists := r.findVariable(name); exists {
+			term.symbol = variable
+			return term, nil
+		}
+		if info, isSyscall := findSyscallByConst(name); isSyscall {
+			term.symbol = info
+			return term, nil
+		}
+
+		function, err := r.resolveFuncValue(term.identifier)
+		if err != nil {
+			return nil, err
+		}
+		term.symbol = function
+		return term, nil
+
+	case NodeFunctionCall:
+		return r.resolveFuncCall(term, true)
+
+	case NodeIndirectCall:
+		return r.resolveIndirectCall(term)
+
+	case NodeTermSyscall:
+		arguments, err := r.resolveSyscall(term.arguments, term.syscall)
+		if err != nil {
+			return nil, err
+		}
+		term.arguments = arguments
+		return term, nil
+
+	case NodeTermRoundBracketExpr:
+		expr, err := r.resolveExpr(term.expr)
+		if err != nil {
+			return nil, err
+		}
+		term.expr = expr
+		return term, nil
+
+	case NodeTermPointer:
+		if variable, exists := r.findVariable(term.identifier.value.MustGetValue()); exists {
+			term.symbol = variable
+			return term, nil
+		}
+
+		function, err := r.resolveFuncValue(term.identifier)
+		if err != nil {
+			return nil, err
+		}
+		term.symbol = function
+		return term, nil
+
+	case NodeTermPointerDereference:
+		variable, exists := r.findVariable(term.identifier.value.MustGetValue())
+		if !exists {
+			return nil, r.undefinedVariable(term.identifier, fmt.Sprintf("undefined variable: %v", term.identifier.value.MustGetValue()))
+		}
+		term.variable = variable
+		return term, nil
+
+	default:
+		panic(fmt.Errorf("resolver error: don't know how to resolve term: %T", rawTerm))
+	}
+}
+
+// a call is to a variable's function value if there's a variable with the
+// name, otherwise to the best matching function or failing that a builtin.
+// calls used as terms have to give back exactly one value
+func (r *Resolver) resolveFuncCall(call NodeFunctionCall, isTerm bool) (NodeFunctionCall, error) {
+	functionName := call.ident.value.MustGetValue()
+
+	if variable, isVariable := r.findVariable(functionName); isVariable {
+		params, err := r.resolveExprs(call.params)
+		if err != nil {
+			return NodeFunctionCall{}, err
+		}
+		call.params = params
+		call.symbol = variable
+		return call, nil
+	}
+
+	functions := r.findFunctions(functionName, call.ident.lineInfo.File)
+	if builtin, isBuiltin := findBuiltin(functionName); len(functions) == 0 && isBuiltin {
+		return r.resolveBuiltinCall(call, builtin, isTerm)
+	}
+	if len(functions) == 0 {
+		err := call.ident.PositionedError(codeUndefinedFunction, fmt.Sprintf("undefined function: '%s'", functionName))
+		if suggestion, ok := closestName(functionName, r.visibleFunctionNames(call.ident.lineInfo.File)); ok {
+			err = err.WithHelp(fmt.Sprintf("did you mean '%s'?", suggestion))
+		}
+		return NodeFunctionCall{}, err
+	}
+
+	// an exact fixed arity match wins, otherwise the variadic
+	// overload with the most fixed parameters is used
+	var function *Function
+	for _, f := range functions {
+		if !f.variadic && len(call.params) == f.parameters {
+			function = f
+			break
+		}
+		if f.variadic && len(call.params) >= f.parameters && (function == nil || f.parameters > function.parameters) {
+			function = f
+		}
+	}
+	if function == nil {
+		err := call.ident.PositionedError(codeArgumentCount, "incorrect number of arguments passed.")
+		for _, f := range functions {
+			err = err.WithLabel(f.ident.span(), fmt.Sprintf("takes %s", f.describeParameters()))
+		}
+		return NodeFunctionCall{}, err
+	}
+	for _, f := range functions {
+		if f.file != function.file && f.parameters == function.parameters && f.variadic == function.variadic {
+			return NodeFunctionCall{}, call.ident.PositionedError(codeAmbiguousCall, fmt.Sprintf("ambiguous call to '%s'. it is imported from both %s and %s", functionName, function.file, f.file)).
+				WithLabel(function.ident.span(), "could be this").
+				WithLabel(f.ident.span(), "or this").
+				WithHelp(fmt.Sprintf("define '%s' in this file to choose which is used", functionName))
+		}
+	}
+	call.symbol = function
+
+	params, err := r.resolveExprs(call.params)
+	if err != nil {
+		return NodeFunctionCall{}, err
+	}
+	call.params = params
+
+	if isTerm && function.returnCount != 1 {
+		return NodeFunctionCall{}, call.ident.PositionedError(codeNotAValue, "function doesn't return any values (or more than 1 atm) so can't be used as a term").
+			WithLabel(function.ident.span(), "returns "+plural(function.returnCount, "value"))
+	}
+
+	return call, nil
+}
+
+func (r *Resolver) resolveBuiltinCall(call NodeFunctionCall, builtin Builtin, isTerm bool) (NodeFunctionCall, error) {
+	if len(call.params) != builtin.arguments {
+		return NodeFunctionCall{}, call.ident.PositionedError(codeBuiltinArgs, fmt.Sprintf("`%s` takes %d arguments", builtin.name, builtin.arguments))
+	}
+	call.symbol = builtin
+
+	firstResolved := 0
+	if builtin.name == "vaCount" || builtin.name == "vaArg" {
+		// `vaCount(args)` and `vaArg(args, i)` name the variadic
+		// parameter which isn't a variable so isn't resolved
+		if r.currentFunction == nil || !r.currentFunction.variadic {
+			return NodeFunctionCall{}, call.ident.PositionedError(codeVariadicBuiltin, fmt.Sprintf("`%s` can only be used in a variadic function", builtin.name))
+		}
+
+		argsIdent, ok := call.params[0].(NodeTermIdentifier)
+		if !ok || argsIdent.identifier.value.MustGetValue() != r.currentFunction.variadicName {
+			return NodeFunctionCall{}, call.ident.PositionedError(codeVariadicBuiltin, fmt.Sprintf("first argument of `%s` must be the variadic parameter: %s", builtin.name, r.currentFunction.variadicName))
+		}
+		firstResolved = 1
+	}
+
+	params := slices.Clone(call.params)
+	for i := firstResolved; i < len(params); i++ {
+		expr, err := r.resolveExpr(params[i])
+		if err != nil {
+			return NodeFunctionCall{}, err
+		}
+		params[i] = expr
+	}
+	call.params = params
+
+	if isTerm && builtin.returns != 1 {
+		return NodeFunctionCall{}, call.ident.PositionedError(codeNotAValue, "function doesn't return any values (or more than 1 atm) so can't be used as a term").
+			WithNote(fmt.Sprintf("`%s` doesn't return a value", builtin.name))
+	}
+
+	return call, nil
+}
+
+func (r *Resolver) resolveIndirectCall(call NodeIndirectCall) (NodeIndirectCall, error) {
+	params, err := r.resolveExprs(call.params)
+	if err != nil {
+		return NodeIndirectCall{}, err
+	}
+	call.params = params
+
+	callee, err := r.resolveExpr(call.callee)
+	if err != nil {
+		return NodeIndirectCall{}, err
+	}
+	call.callee = callee
+
+	return call, nil
+}
+
+func (r *Resolver) resolveSyscall(arguments []NodeExpr, syscallTok Token) ([]NodeExpr, error) {
+	resolved, err := r.resolveExprs(arguments)
+	if err != nil {
+		return nil, err
+	}
+
+	err = r.checkSyscallArgs(resolved, syscallTok)
+	if err != nil {
+		return nil, err
+	}
+	return resolved, nil
+}
+
+// only functions with a single overload can be referred to by name alone
+func (r *Resolver) resolveFuncValue(ident Token) (*Function, error) {
+	functionName := ident.value.MustGetValue()
+
+	functions := r.findFunctions(functionName, ident.lineInfo.File)
+	if len(functions) == 0 {
+		return nil, r.undefinedVariable(ident, fmt.Sprintf("undefined variable or function: %v", functionName))
+	}
+	if len(functions) > 1 {
+		err := ident.PositionedError(codeOverloadedValue, fmt.Sprintf("can't take the address of overloaded function: %v", functionName))
+		for _, f := range functions {
+			err = err.WithLabel(f.ident.span(), "overload defined here")
+		}
+		return nil, err
+	}
+	if functions[0].variadic {
+		return nil, ident.PositionedError(codeVariadicValue, fmt.Sprintf("can't take the address of variadic function: %v", functionName))
+	}
+	return functions[0], nil
+}
+
+var asmRegisters = []string{"rax", "rbx", "rcx", "rdx", "rsi", "rdi", "rbp", "r8", "r9", "r10", "r11", "r12", "r13", "r14", "r15"}
+
+func (r *Resolver) resolveAsm(stmt NodeStmtAsm) (NodeStmtAsm, error) {
+	for _, c := range stmt.clobbers {
+		if !slices.Contains(asmRegisters, c.value.MustGetValue()) {
+			return NodeStmtAsm{}, c.PositionedError(codeBadClobber, fmt.Sprintf("can't clobber register: %s", c.value.MustGetValue())).
+				WithNote("the registers that can be clobbered are " + strings.Join(asmRegisters, ", "))
+		}
+	}
+
+	stmt.variables = map[string]*Variable{}
+	_, err := expandAsmBody(stmt.body, func(name string, lineInfo LineInfo) (string, error) {
+		variable, exists := r.findVariable(name)
+		if !exists {
+			return "", Diagnostic{
+				code:    codeUndefinedAsmOperand,
+				message: fmt.Sprintf("undefined variable in asm: '%s'", name),
+				span:    Span{start: lineInfo, length: utf8.RuneCountInString(name) + 1},
+			}
+		}
+		stmt.variables[name] = variable
+		return "", nil
+	})
+	if err != nil {
+		return NodeStmtAsm{}, err
+	}
+
+	return stmt, nil
+}
+
+func (r *Resolver) beginScope() {
+	r.scopes = append(r.scopes, []*Variable{})
+}
+func (r *Resolver) endScope() {
+	r.scopes = r.scopes[:len(r.scopes)-1]
+}
+
+func (r *Resolver) declareVariable(ident Token, isParameter bool) (*Variable, error) {
+	name := ident.value.MustGetValue()
+	if previous, exists := r.findVariable(name); exists {
+		return nil, ident.PositionedError(codeDuplicateVariable, fmt.Sprintf("variable identifier already used: %v", name)).
+			WithLabel(previous.ident.span(), "previous declaration here").
+			WithNote("variables can't shadow a variable from an enclosing scope")
+	}
+
+	variable := &Variable{name: name, ident: ident, isParameter: isParameter}
+	r.scopes[len(r.scopes)-1] = append(r.scopes[len(r.scopes)-1], variable)
+	return variable, nil
+}
+
+func (r *Resolver) findVariable(name string) (*Variable, bool) {
+	for i := len(r.scopes) - 1; i >= 0; i-- {
+		for _, v := range r.scopes[i] {
+			if v.name == name {
+				return v, true
+			}
+		}
+	}
+	return nil, false
+}
+
+func (r *Resolver) undefinedVariable(ident Token, message string) Diagnostic {
+	err := ident.PositionedError(codeUndefinedVariable, message)
+
+	candidates := []string{}
+	for _, scope := range r.scopes {
+		for _, v := range scope {
+			candidates = append(candidates, v.name)
+		}
+	}
+	if suggestion, ok := closestName(ident.value.MustGetValue(), candidates); ok {
+		err = err.WithHelp(fmt.Sprintf("did you mean '%s'?", suggestion))
+	}
+	return err
+}
+
+func (r *Resolver) visibleFunctionNames(fromFile string) []string {
+	names := []string{}
+	for _, f := range r.functions {
+		if !slices.Contains(names, f.name) && len(r.findFunctions(f.name, fromFile)) > 0 {
+			names = append(names, f.name)
+		}
+	}
+	return names
+}
+
+// finds the functions with a name that can be seen from a file.
+// that is the file's own functions and those of the files it imports,
+// with the file's own functions hiding imported ones of the same arity
+// and any other file's functions hiding the standard library's
+func (r *Resolver) findFunctions(name string, fromFile string) []*Function {
+	candidates := []*Function{}
+	for _, f := range r.functions {
+		if f.name == name {
+			candidates = append(candidates, f)
+		}
+	}
+
+	visible := func(f *Function) bool {
+		return f.file == fromFile || slices.Contains(r.program.files[fromFile].imports, f.file)
+	}
+	hidden := func(f *Function) bool {
+		if f.file == fromFile {
+			return false
+		}
+		for _, other := range candidates {
+			if other.parameters != f.parameters || other.variadic != f.variadic || !visible(other) {
+				continue
+			}
+			if other.file == fromFile || (isStdFile(f.file) && !isStdFile(other.file)) {
+				return true
+			}
+		}
+		return false
+	}
+
+	found := []*Function{}
+	for _, f := range candidates {
+		if visible(f) && !hidden(f) {
+			found = append(found, f)
+		}
+	}
+	return found
+}
+
+type Variable struct {
+	name  string
+	ident Token
+
+	isParameter bool
+}
+
+type Function struct {
+	name        string
+	ident       Token
+	returnCount int
+	parameters  int
+
+	variadic     bool
+	variadicName string
+
+	// marked to always be inlined where it's called
+	inline bool
+
+	file      string
+	namespace string
+}
+
+// arity is part of the label so functions can be overloaded and
+// functions from imported files are prefixed with their file's namespace.
+// variadic functions are marked with a `v` before their fixed parameter count
+func (f Function) label() string {
+	if f.variadic {
+		return fmt.Sprintf("%s%s_v%d", f.namespace, asmSafeName(f.name), f.parameters)
+	}
+	return fmt.Sprintf("%s%s_%d", f.namespace, asmSafeName(f.name), f.parameters)
+}
+
+// assemblers only accept ASCII in labels so any
+// other characters are written as their code point
+func asmSafeName(name string) string {
+	safe := ""
+	for _, c := range name {
+		if c <= unicode.MaxASCII {
+			safe += string(c)
+		} else {
+			safe += fmt.Sprintf("_u%X_", c)
+		}
+	}
+	return safe
+}
+
+// how many arguments the function takes, for error messages
+func (f Function) describeParameters() string {
+	if f.variadic {
+		return "at least " + plural(f.parameters, "argument")
+	}
+	return plural(f.parameters, "argument")
+}
+
+// the position above rbp of the first parameter in qwords.
+// variadic functions have their hidden argument count before it
+func (f Function) firstParamLoc() int {
+	if f.variadic {
+		return 3
+	}
+	return 2
+}
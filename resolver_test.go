@@ -0,0 +1,62 @@
+package main
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestResolveErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		source string
+		want   []string
+	}{
+		{"valid", "func 1 f(a) { return a; }\nvar x = f(1);\nx = x + 1;", []string{}},
+		{"undefined variable", "x = 1;", []string{codeUndefinedVariable}},
+		{"undefined function", "f(1);", []string{codeUndefinedFunction}},
+		{"out of scope", "if (1) { var x = 1; }\nx = 2;", []string{codeUndefinedVariable}},
+		{"duplicate variable", "var x;\nvar x;", []string{codeDuplicateVariable}},
+		{"duplicate function", "func 0 f() {}\nfunc 0 f() {}", []string{codeDuplicateFunction}},
+		{"overloaded by arity", "func 0 f() {}\nfunc 0 f(a) {}\nf();\nf(1);", []string{}},
+		{"argument count", "func 0 f(a) {}\nf(1, 2);", []string{codeArgumentCount}},
+		{"not a value", "func 0 f() {}\nvar x = f();", []string{codeNotAValue}},
+		{"return count", "func 1 f() { return; }", []string{codeReturnCount}},
+		{"missing return", "func 1 f(a) { if (a) { return 1; } }", []string{codeMissingReturn}},
+		{"top level return", "return 1, 2;", []string{codeTopLevelReturn}},
+		{"break outside loop", "break;", []string{codeOutsideLoop}},
+		{"nested function", "func 0 f() { func 0 g() {} }", []string{codeNestedFunction}},
+		{"asm operand", "asm { mov rax, %y }", []string{codeUndefinedAsmOperand}},
+		{"bad clobber", "asm (rsp) { nop }", []string{codeBadClobber}},
+		{"every error", "x = 1;\ny = 2;\nbreak;", []string{codeUndefinedVariable, codeUndefinedVariable, codeOutsideLoop}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := resolveSource(t, test.source)
+			got := []string{}
+			if err != nil {
+				got = errorCodes(err)
+			}
+			if !slices.Equal(got, test.want) {
+				t.Errorf("got %v, want %v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestResolveBindings(t *testing.T) {
+	prog, err := resolveSource(t, "func 1 f(a) { return a; }\nvar x = 2;\nvar y = f(x);")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the call is to the function and its argument is the variable
+	decl := prog.stmts[len(prog.stmts)-1].(NodeStmtVarDeclare)
+	call := decl.expr.MustGetValue().(NodeFunctionCall)
+	if _, ok := call.symbol.(*Function); !ok {
+		t.Errorf("call bound to %T, want the function", call.symbol)
+	}
+	arg := call.params[0].(NodeTermIdentifier)
+	if _, ok := arg.symbol.(*Variable); !ok {
+		t.Errorf("argument bound to %T, want the variable", arg.symbol)
+	}
+}
@@ -1,3 +1,3 @@
 #! /bin/bash
 
-nasm -felf64 build/$1 -o build/out.o && ld build/out.o -o build/out && ./build/out; echo $?
+nasm -felf64 build/$1 -o build/out.o && ld build/out.o -o build/out && ./build/out "${@:2}"; echo $?
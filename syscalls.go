@@ -0,0 +1,154 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+)
+
+// a Linux x86-64 syscall. some take optional trailing
+// arguments so have a range of argument counts
+type SyscallInfo struct {
+	name    string
+	number  int
+	minArgs int
+	maxArgs int
+}
+
+var syscallTable = []SyscallInfo{
+	{"read", 0, 3, 3},
+	{"write", 1, 3, 3},
+	{"open", 2, 2, 3},
+	{"close", 3, 1, 1},
+	{"stat", 4, 2, 2},
+	{"fstat", 5, 2, 2},
+	{"lstat", 6, 2, 2},
+	{"poll", 7, 3, 3},
+	{"lseek", 8, 3, 3},
+	{"mmap", 9, 6, 6},
+	{"mprotect", 10, 3, 3},
+	{"munmap", 11, 2, 2},
+	{"brk", 12, 1, 1},
+	{"rt_sigaction", 13, 4, 4},
+	{"rt_sigprocmask", 14, 4, 4},
+	{"ioctl", 16, 2, 3},
+	{"pread64", 17, 4, 4},
+	{"pwrite64", 18, 4, 4},
+	{"readv", 19, 3, 3},
+	{"writev", 20, 3, 3},
+	{"access", 21, 2, 2},
+	{"pipe", 22, 1, 1},
+	{"select", 23, 5, 5},
+	{"sched_yield", 24, 0, 0},
+	{"dup", 32, 1, 1},
+	{"dup2", 33, 2, 2},
+	{"pause", 34, 0, 0},
+	{"nanosleep", 35, 2, 2},
+	{"alarm", 37, 1, 1},
+	{"getpid", 39, 0, 0},
+	{"socket", 41, 3, 3},
+	{"connect", 42, 3, 3},
+	{"accept", 43, 3, 3},
+	{"sendto", 44, 6, 6},
+	{"recvfrom", 45, 6, 6},
+	{"bind", 49, 3, 3},
+	{"listen", 50, 2, 2},
+	{"clone", 56, 5, 5},
+	{"fork", 57, 0, 0},
+	{"vfork", 58, 0, 0},
+	{"execve", 59, 3, 3},
+	{"exit", 60, 1, 1},
+	{"wait4", 61, 4, 4},
+	{"kill", 62, 2, 2},
+	{"uname", 63, 1, 1},
+	{"fcntl", 72, 2, 3},
+	{"fsync", 74, 1, 1},
+	{"truncate", 76, 2, 2},
+	{"ftruncate", 77, 2, 2},
+	{"getcwd", 79, 2, 2},
+	{"chdir", 80, 1, 1},
+	{"rename", 82, 2, 2},
+	{"mkdir", 83, 2, 2},
+	{"rmdir", 84, 1, 1},
+	{"creat", 85, 2, 2},
+	{"link", 86, 2, 2},
+	{"unlink", 87, 1, 1},
+	{"symlink", 88, 2, 2},
+	{"readlink", 89, 3, 3},
+	{"chmod", 90, 2, 2},
+	{"chown", 92, 3, 3},
+	{"umask", 95, 1, 1},
+	{"gettimeofday", 96, 2, 2},
+	{"getuid", 102, 0, 0},
+	{"getgid", 104, 0, 0},
+	{"geteuid", 107, 0, 0},
+	{"getegid", 108, 0, 0},
+	{"getppid", 110, 0, 0},
+	{"gettid", 186, 0, 0},
+	{"time", 201, 1, 1},
+	{"clock_gettime", 228, 2, 2},
+	{"clock_nanosleep", 230, 4, 4},
+	{"exit_group", 231, 1, 1},
+	{"openat", 257, 3, 4},
+	{"getrandom", 318, 3, 3},
+}
+
+// syscall numbers can be written as `SYS_name` instead of the number
+const syscallConstPrefix = "SYS_"
+
+func findSyscallByConst(ident string) (SyscallInfo, bool) {
+	for _, s := range syscallTable {
+		if syscallConstPrefix+s.name == ident {
+			return s, true
+		}
+	}
+	return SyscallInfo{}, false
+}
+
+func findSyscallByNumber(number int) (SyscallInfo, bool) {
+	for _, s := range syscallTable {
+		if s.number == number {
+			return s, true
+		}
+	}
+	return SyscallInfo{}, false
+}
+
+// the syscall a resolved syscall number refers to if
+// it's a literal or `SYS_name` constant in the table
+func knownSyscall(number NodeExpr) (SyscallInfo, bool) {
+	switch number := number.(type) {
+	case NodeTermIntLiteral:
+		n, err := strconv.Atoi(number.intLiteral.value.MustGetValue())
+		if err == nil {
+			return findSyscallByNumber(n)
+		}
+	case NodeTermIdentifier:
+		info, ok := number.symbol.(SyscallInfo)
+		return info, ok
+	}
+	return SyscallInfo{}, false
+}
+
+// checks the number of arguments given to a syscall whose number is
+// known at compile time. unknown syscalls can take up to 6 arguments
+func (r *Resolver) checkSyscallArgs(arguments []NodeExpr, syscallTok Token) error {
+	if len(arguments) == 0 {
+		return syscallTok.PositionedError(codeSyscallArgs, "syscall needs at least the syscall number")
+	}
+
+	info, known := knownSyscall(arguments[0])
+	if !known {
+		return nil
+	}
+
+	given := len(arguments) - 1
+	if given >= info.minArgs && given <= info.maxArgs {
+		return nil
+	}
+
+	expected := fmt.Sprint(info.minArgs)
+	if info.minArgs != info.maxArgs {
+		expected = fmt.Sprintf("%d to %d", info.minArgs, info.maxArgs)
+	}
+	return syscallTok.PositionedError(codeSyscallArgs, fmt.Sprintf("syscall %s takes %s arguments but was given %d", info.name, expected, given))
+}
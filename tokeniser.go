@@ -2,7 +2,9 @@ package main
 
 import (
 	"fmt"
+	"strings"
 	"unicode"
+	"unicode/utf8"
 
 	opt "github.com/moltenwolfcub/moltenCompiler/optional"
 )
@@ -30,10 +32,16 @@ const (
 	_break
 	_continue
 	_func
+	_inline
 	comma
 	_return
 	syscall
 	ampersand
+	ellipsis
+	asm
+	asmBody
+	_import
+	stringLiteral
 )
 
 func (t TokenType) GetBinPrec() opt.Optional[int] {
@@ -54,11 +62,98 @@ type Token struct {
 	lineInfo LineInfo
 }
 
+// how the tokens with fixed text are written
+var tokenSpellings = map[TokenType]string{
+	semiColon:         ";",
+	openRoundBracket:  "(",
+	closeRoundBracket: ")",
+	_var:              "var",
+	equals:            "=",
+	plus:              "+",
+	asterisk:          "*",
+	minus:             "-",
+	fslash:            "/",
+	percent:           "%",
+	openCurlyBracket:  "{",
+	closeCurlyBracket: "}",
+	_if:               "if",
+	while:             "while",
+	_else:             "else",
+	_break:            "break",
+	_continue:         "continue",
+	_func:             "func",
+	_inline:           "inline",
+	comma:             ",",
+	_return:           "return",
+	syscall:           "syscall",
+	ampersand:         "&",
+	ellipsis:          "...",
+	asm:               "asm",
+	_import:           "import",
+}
+
+// the token as it was written in the source
+func (t Token) text() string {
+	switch t.tokenType {
+	case identifier, intLiteral, asmBody:
+		return t.value.MustGetValue()
+	case stringLiteral:
+		return "\"" + t.value.MustGetValue() + "\""
+	default:
+		return tokenSpellings[t.tokenType]
+	}
+}
+
+// the position straight after the token
+func (t Token) end() LineInfo {
+	end := t.lineInfo
+	for _, c := range t.text() {
+		if c == '\n' {
+			end.NextLine()
+		} else {
+			end.IncColumn()
+		}
+	}
+	return end
+}
+
+// the columns the token covers. tokens over
+// several lines only cover the rest of their first line
+func (t Token) span() Span {
+	text, _, _ := strings.Cut(t.text(), "\n")
+	return Span{start: t.lineInfo, length: max(utf8.RuneCountInString(text), 1)}
+}
+
+// an error pointing at the whole token
+func (t Token) PositionedError(code string, message string) Diagnostic {
+	return Diagnostic{code: code, message: message, span: t.span()}
+}
+
+// whether a `{` after the tokens so far starts an asm body, which
+// is kept verbatim. it does straight after the `asm` keyword or
+// the registers in brackets after it
+func startsAsmBody(tokens []Token) bool {
+	i := len(tokens) - 1
+	if i >= 0 && tokens[i].tokenType == closeRoundBracket {
+		i--
+		for i >= 0 && (tokens[i].tokenType == identifier || tokens[i].tokenType == comma) {
+			i--
+		}
+		if i < 0 || tokens[i].tokenType != openRoundBracket {
+			return false
+		}
+		i--
+	}
+	return i >= 0 && tokens[i].tokenType == asm
+}
+
 type Tokeniser struct {
 	program      string
 	currentIndex int
 
 	currentLineInfo LineInfo
+
+	errors ErrorList
 }
 
 func NewTokeniser(program string, fileName string) Tokeniser {
@@ -72,6 +167,17 @@ func (t *Tokeniser) Tokenise() ([]Token, error) {
 	tokens := []Token{}
 	buf := []rune{}
 
+	// the program can't be split into code points if it isn't valid UTF-8
+	err := t.checkEncoding()
+	if err != nil {
+		return nil, err
+	}
+
+	// a byte order mark isn't part of the program
+	if t.peek().HasValue() && t.peek().MustGetValue() == '\uFEFF' {
+		t.consume()
+	}
+
 	for t.peek().HasValue() {
 		if t.peek().MustGetValue() == '\n' {
 			t.consume()
@@ -96,6 +202,40 @@ func (t *Tokeniser) Tokenise() ([]Token, error) {
 			tokens = append(tokens, Token{tokenType: closeRoundBracket, lineInfo: t.currentLineInfo})
 			t.currentLineInfo.IncColumn()
 
+		} else if t.peek().MustGetValue() == '{' && startsAsmBody(tokens) {
+			t.consume()
+			tokens = append(tokens, Token{tokenType: openCurlyBracket, lineInfo: t.currentLineInfo})
+			t.currentLineInfo.IncColumn()
+
+			// braces in the assembly, like avx masks, come in pairs
+			// so the body ends at the `}` that isn't one of them
+			bodyInfo := t.currentLineInfo
+			depth := 0
+			for {
+				if !t.peek().HasValue() {
+					t.errors.Add(bodyInfo.PositionedError(codeUnclosedAsm, "asm block wasn't closed. terminate it with `}`"))
+					return tokens, t.errors.Err()
+				}
+				if t.peek().MustGetValue() == '}' {
+					if depth == 0 {
+						break
+					}
+					depth--
+				} else if t.peek().MustGetValue() == '{' {
+					depth++
+				}
+
+				c := t.consume()
+				buf = append(buf, c)
+				if c == '\n' {
+					t.currentLineInfo.NextLine()
+				} else {
+					t.currentLineInfo.IncColumn()
+				}
+			}
+			tokens = append(tokens, Token{tokenType: asmBody, value: opt.ToOptional(string(buf)), lineInfo: bodyInfo})
+			buf = []rune{}
+
 		} else if t.peek().MustGetValue() == '{' {
 			t.consume()
 			tokens = append(tokens, Token{tokenType: openCurlyBracket, lineInfo: t.currentLineInfo})
@@ -141,6 +281,39 @@ func (t *Tokeniser) Tokenise() ([]Token, error) {
 			tokens = append(tokens, Token{tokenType: ampersand, lineInfo: t.currentLineInfo})
 			t.currentLineInfo.IncColumn()
 
+		} else if t.peek().MustGetValue() == '"' {
+			startInfo := t.currentLineInfo
+			t.consume()
+			t.currentLineInfo.IncColumn()
+			for {
+				if !t.peek().HasValue() || t.peek().MustGetValue() == '\n' {
+					t.errors.Add(startInfo.PositionedError(codeUnclosedString, "string literal wasn't closed. terminate it with '\"'"))
+					break
+				}
+				c := t.consume()
+				t.currentLineInfo.IncColumn()
+				if c == '"' {
+					break
+				}
+				buf = append(buf, c)
+			}
+			tokens = append(tokens, Token{tokenType: stringLiteral, value: opt.ToOptional(string(buf)), lineInfo: startInfo})
+			buf = []rune{}
+
+		} else if t.peek().MustGetValue() == '.' {
+			startInfo := t.currentLineInfo
+			dots := 0
+			for dots < 3 && t.peek().HasValue() && t.peek().MustGetValue() == '.' {
+				t.consume()
+				t.currentLineInfo.IncColumn()
+				dots++
+			}
+			if dots != 3 {
+				t.errors.Add(startInfo.PositionedError(codeInvalidToken, "invalid token: expected `...`"))
+				continue
+			}
+			tokens = append(tokens, Token{tokenType: ellipsis, lineInfo: startInfo})
+
 		} else if t.peek().MustGetValue() == '/' {
 			t.consume()
 			if t.peek().HasValue() && t.peek().MustGetValue() == '/' {
@@ -155,7 +328,8 @@ func (t *Tokeniser) Tokenise() ([]Token, error) {
 				t.currentLineInfo.IncColumn()
 				for {
 					if !t.peek().HasValue() {
-						return nil, t.currentLineInfo.PositionedError("multiline comment wasn't closed. terminate it with `*/`")
+						t.errors.Add(t.currentLineInfo.PositionedError(codeUnclosedComment, "multiline comment wasn't closed. terminate it with `*/`"))
+						return tokens, t.errors.Err()
 					}
 
 					c := t.consume()
@@ -208,6 +382,10 @@ func (t *Tokeniser) Tokenise() ([]Token, error) {
 				tokens = append(tokens, Token{tokenType: _func, lineInfo: t.currentLineInfo})
 				t.currentLineInfo.IncWord(buf)
 				buf = []rune{}
+			} else if string(buf) == "inline" {
+				tokens = append(tokens, Token{tokenType: _inline, lineInfo: t.currentLineInfo})
+				t.currentLineInfo.IncWord(buf)
+				buf = []rune{}
 			} else if string(buf) == "return" {
 				tokens = append(tokens, Token{tokenType: _return, lineInfo: t.currentLineInfo})
 				t.currentLineInfo.IncWord(buf)
@@ -216,15 +394,23 @@ func (t *Tokeniser) Tokenise() ([]Token, error) {
 				tokens = append(tokens, Token{tokenType: syscall, lineInfo: t.currentLineInfo})
 				t.currentLineInfo.IncWord(buf)
 				buf = []rune{}
+			} else if string(buf) == "import" {
+				tokens = append(tokens, Token{tokenType: _import, lineInfo: t.currentLineInfo})
+				t.currentLineInfo.IncWord(buf)
+				buf = []rune{}
+			} else if string(buf) == "asm" {
+				tokens = append(tokens, Token{tokenType: asm, lineInfo: t.currentLineInfo})
+				t.currentLineInfo.IncWord(buf)
+				buf = []rune{}
 			} else {
 				tokens = append(tokens, Token{tokenType: identifier, value: opt.ToOptional(string(buf)), lineInfo: t.currentLineInfo})
 				t.currentLineInfo.IncWord(buf)
 				buf = []rune{}
 			}
 
-		} else if unicode.IsDigit(t.peek().MustGetValue()) {
+		} else if isASCIIDigit(t.peek().MustGetValue()) {
 			buf = append(buf, t.consume())
-			for t.peek().HasValue() && unicode.IsDigit(t.peek().MustGetValue()) {
+			for t.peek().HasValue() && isASCIIDigit(t.peek().MustGetValue()) {
 				buf = append(buf, t.consume())
 			}
 
@@ -233,22 +419,51 @@ func (t *Tokeniser) Tokenise() ([]Token, error) {
 			buf = []rune{}
 
 		} else {
-			return nil, t.currentLineInfo.PositionedError(fmt.Sprintf("invalid token: %c", t.peek().MustGetValue()))
+			// skip the character so the rest of the file is still checked
+			t.errors.Add(t.currentLineInfo.PositionedError(codeInvalidToken, fmt.Sprintf("invalid token: %c", t.consume())))
+			t.currentLineInfo.IncColumn()
 		}
 	}
 
-	return tokens, nil
+	return tokens, t.errors.Err()
+}
+
+// the program must be valid UTF-8 so the rest of the
+// tokeniser can work with whole code points
+func (t Tokeniser) checkEncoding() error {
+	errs := ErrorList{}
+	lineInfo := t.currentLineInfo
+	for i := 0; i < len(t.program); {
+		r, size := utf8.DecodeRuneInString(t.program[i:])
+		if r == utf8.RuneError && size == 1 {
+			errs.Add(lineInfo.PositionedError(codeInvalidUTF8, fmt.Sprintf("invalid UTF-8 byte: 0x%02X", t.program[i])))
+		}
+
+		if r == '\n' {
+			lineInfo.NextLine()
+		} else {
+			lineInfo.IncColumn()
+		}
+		i += size
+	}
+	return errs.Err()
+}
+
+// int literals are only written with 0-9, not the digits of other scripts
+func isASCIIDigit(r rune) bool {
+	return r >= '0' && r <= '9'
 }
 
 func (t Tokeniser) peek() opt.Optional[rune] {
 	if t.currentIndex >= len(t.program) {
 		return opt.NewOptional[rune]()
 	}
-	return opt.ToOptional(rune(t.program[t.currentIndex]))
+	r, _ := utf8.DecodeRuneInString(t.program[t.currentIndex:])
+	return opt.ToOptional(r)
 }
 
 func (t *Tokeniser) consume() rune {
-	r := rune(t.program[t.currentIndex])
-	t.currentIndex++
+	r, size := utf8.DecodeRuneInString(t.program[t.currentIndex:])
+	t.currentIndex += size
 	return r
 }
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"slices"
+	"testing"
+)
+
+// the text of each token
+func tokenTexts(tokens []Token) []string {
+	texts := []string{}
+	for _, tok := range tokens {
+		texts = append(texts, tok.text())
+	}
+	return texts
+}
+
+func TestTokenise(t *testing.T) {
+	tests := []struct {
+		name   string
+		source string
+		want   []string
+	}{
+		{"declaration", "var x = 12;", []string{"var", "x", "=", "12", ";"}},
+		{"operators", "a+b*c-d/e%f", []string{"a", "+", "b", "*", "c", "-", "d", "/", "e", "%", "f"}},
+		{"keywords", "if while else break continue func inline return import", []string{"if", "while", "else", "break", "continue", "func", "inline", "return", "import"}},
+		{"line comment", "x; // y;\nz;", []string{"x", ";", "z", ";"}},
+		{"block comment", "x /* y; */ z", []string{"x", "z"}},
+		{"string", `import "lib/a.mltn";`, []string{"import", `"lib/a.mltn"`, ";"}},
+		{"ellipsis", "func 1 f(...xs) {}", []string{"func", "1", "f", "(", "...", "xs", ")", "{", "}"}},
+		{"unicode identifier", "var größe = 1;", []string{"var", "größe", "=", "1", ";"}},
+		{"byte order mark", "\uFEFFx;", []string{"x", ";"}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			tokeniser := NewTokeniser(test.source, "main.mltn")
+			tokens, err := tokeniser.Tokenise()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := tokenTexts(tokens); !slices.Equal(got, test.want) {
+				t.Errorf("got %q, want %q", got, test.want)
+			}
+		})
+	}
+}
+
+func TestTokeniseErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		source string
+		want   []string
+	}{
+		{"invalid character", "var x = 1 # 2;", []string{codeInvalidToken}},
+		{"non ascii digit", "var x = ٣;", []string{codeInvalidToken}},
+		{"every invalid character", "# x @", []string{codeInvalidToken, codeInvalidToken}},
+		{"unclosed string", `import "a.mltn;`, []string{codeUnclosedString}},
+		{"unclosed comment", "x /* y", []string{codeUnclosedComment}},
+		{"two dots", "f(..xs)", []string{codeInvalidToken}},
+		{"invalid utf8", "var x\xff = 1;", []string{codeInvalidUTF8}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			tokeniser := NewTokeniser(test.source, "main.mltn")
+			_, err := tokeniser.Tokenise()
+			if got := errorCodes(err); !slices.Equal(got, test.want) {
+				t.Errorf("got %v, want %v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestTokenPositions(t *testing.T) {
+	// columns count code points rather than bytes
+	tokeniser := NewTokeniser("var größe = 1;\n  x;", "main.mltn")
+	tokens, err := tokeniser.Tokenise()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := [][2]int{{1, 1}, {1, 5}, {1, 11}, {1, 13}, {1, 14}, {2, 3}, {2, 4}}
+	if len(tokens) != len(want) {
+		t.Fatalf("got %d tokens, want %d", len(tokens), len(want))
+	}
+	for i, tok := range tokens {
+		got := [2]int{tok.lineInfo.Line, tok.lineInfo.Col}
+		if got != want[i] {
+			t.Errorf("token %q at %v, want %v", tok.text(), got, want[i])
+		}
+	}
+}
+
+func TestTokeniseAsm(t *testing.T) {
+	tests := []struct {
+		name   string
+		source string
+		want   []string
+	}{
+		{"body", "asm { mov rax, %x }", []string{"asm", "{", " mov rax, %x ", "}"}},
+		{"registers", "asm (rbx, r12) {push 1}", []string{"asm", "(", "rbx", ",", "r12", ")", "{", "push 1", "}"}},
+		{"paired braces", "asm {a {k1} b}", []string{"asm", "{", "a {k1} b", "}"}},
+		{"only straight after asm", "asm; if (x) {y;}", []string{"asm", ";", "if", "(", "x", ")", "{", "y", ";", "}"}},
+		{"not after other brackets", "asm f(x) {y;}", []string{"asm", "f", "(", "x", ")", "{", "y", ";", "}"}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			tokeniser := NewTokeniser(test.source, "main.mltn")
+			tokens, err := tokeniser.Tokenise()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := tokenTexts(tokens); !slices.Equal(got, test.want) {
+				t.Errorf("got %q, want %q", got, test.want)
+			}
+		})
+	}
+
+	tokeniser := NewTokeniser("asm { mov rax, 1 {", "main.mltn")
+	_, err := tokeniser.Tokenise()
+	if got := errorCodes(err); !slices.Equal(got, []string{codeUnclosedAsm}) {
+		t.Errorf("got %v for an unclosed body, want %v", got, []string{codeUnclosedAsm})
+	}
+}
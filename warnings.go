@@ -0,0 +1,390 @@
+package main
+
+import (
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+)
+
+/*
+	Warnings point out code that compiles but probably isn't what was meant.
+	The linter runs after the resolver so every name is already bound to
+	its symbol. Each kind of warning can be turned off or made into an
+	error from the command line, E.G. `-W=no-unused-function` or
+	`-Werror=unreachable-code`. The standard library is never warned about.
+*/
+
+type WarningKind struct {
+	name string
+	code string
+}
+
+var warningKinds = []WarningKind{
+	{"unused-variable", codeUnusedVariable},
+	{"unused-function", codeUnusedFunction},
+	{"unreachable-code", codeUnreachableCode},
+	{"constant-condition", codeConstantCondition},
+	{"uninitialised", codeUninitialised},
+}
+
+func findWarningKind(name string) (WarningKind, bool) {
+	for _, k := range warningKinds {
+		if k.name == name {
+			return k, true
+		}
+	}
+	return WarningKind{}, false
+}
+
+func findWarningKindByCode(code string) WarningKind {
+	for _, k := range warningKinds {
+		if k.code == code {
+			return k
+		}
+	}
+	panic(fmt.Errorf("linter error: unknown warning code: %s", code))
+}
+
+// which warnings are reported and which of those are errors, by code
+type WarningConfig struct {
+	enabled  map[string]bool
+	asErrors map[string]bool
+}
+
+func ParseWarningConfig(enabled string, asErrors string) (WarningConfig, error) {
+	allCodes := map[string]bool{}
+	for _, k := range warningKinds {
+		allCodes[k.code] = true
+	}
+
+	enabledCodes, err := parseWarningList("-W", enabled, allCodes)
+	if err != nil {
+		return WarningConfig{}, err
+	}
+	errorCodes, err := parseWarningList("-Werror", asErrors, map[string]bool{})
+	if err != nil {
+		return WarningConfig{}, err
+	}
+	return WarningConfig{enabled: enabledCodes, asErrors: errorCodes}, nil
+}
+
+// a comma separated list of warning names, later names overriding earlier
+// ones. `all` is every warning and `no-` before a name leaves it out. a
+// list that only leaves warnings out takes them from the flag's defaults
+func parseWarningList(flagName string, list string, defaults map[string]bool) (map[string]bool, error) {
+	codes := map[string]bool{}
+	if list == "" {
+		return codes, nil
+	}
+
+	names := strings.Split(list, ",")
+	if !slices.ContainsFunc(names, func(name string) bool { return !strings.HasPrefix(strings.TrimSpace(name), "no-") }) {
+		maps.Copy(codes, defaults)
+	}
+
+	for _, name := range names {
+		name, exclude := strings.CutPrefix(strings.TrimSpace(name), "no-")
+
+		if name == "all" {
+			for _, k := range warningKinds {
+				codes[k.code] = !exclude
+			}
+			continue
+		}
+
+		kind, ok := findWarningKind(name)
+		if !ok {
+			names := []string{}
+			for _, k := range warningKinds {
+				names = append(names, k.name)
+			}
+			return nil, fmt.Errorf("%s: unknown warning '%s'. the warnings are all, %s", flagName, name, strings.Join(names, ", "))
+		}
+		codes[kind.code] = !exclude
+	}
+	return codes, nil
+}
+
+type Linter struct {
+	program NodeProg
+	config  WarningConfig
+
+	// variables declared with `var` in the order they're declared
+	declared []*Variable
+	read     map[*Variable]bool
+	assigned map[*Variable]bool
+
+	// functions used anywhere but their own body
+	used map[*Function]bool
+
+	// nil in top level code
+	currentFunction *Function
+
+	warnings ErrorList
+}
+
+func NewLinter(prog NodeProg, config WarningConfig) Linter {
+	return Linter{
+		program: prog,
+		config:  config,
+
+		declared: []*Variable{},
+		read:     map[*Variable]bool{},
+		assigned: map[*Variable]bool{},
+		used:     map[*Function]bool{},
+	}
+}
+
+// the warnings in order of where they are in the source.
+// some of them are errors if they were made errors by the config
+func (l *Linter) Lint() ErrorList {
+	l.lintStmts(l.program.init)
+	l.lintStmts(l.program.stmts)
+	l.checkInitialisation()
+
+	for _, v := range l.declared {
+		if l.read[v] {
+			continue
+		}
+		warning := v.ident.PositionedError(codeUnusedVariable, fmt.Sprintf("unused variable: '%s'", v.name))
+		if l.assigned[v] {
+			warning = warning.WithNote("it's assigned to but its value is never read")
+		}
+		l.warn(warning)
+	}
+
+	for _, stmt := range l.program.stmts {
+		funcStmt, ok := stmt.(NodeStmtFunctionDefinition)
+		if !ok {
+			continue
+		}
+		function := funcStmt.function
+
+		// functions in other files can be used by whatever imports them
+		if function.file != l.program.mainFile || l.used[function] {
+			continue
+		}
+		if l.program.main.HasValue() && l.program.main.MustGetValue() == function {
+			continue
+		}
+		l.warn(function.ident.PositionedError(codeUnusedFunction, fmt.Sprintf("function '%s' taking %s is never used", function.name, function.describeParameters())))
+	}
+
+	warnings, _ := l.warnings.Err().(ErrorList)
+
+	// how to turn each kind of warning off is only mentioned the first time
+	reported := map[string]bool{}
+	for i, w := range warnings {
+		warning := w.(Diagnostic)
+		if reported[warning.code] {
+			continue
+		}
+		reported[warning.code] = true
+
+		kind := findWarningKindByCode(warning.code)
+		if warning.severity == severityError {
+			warnings[i] = warning.WithNote(fmt.Sprintf("this is an error because of `-Werror=%s`", kind.name))
+		} else {
+			warnings[i] = warning.WithNote(fmt.Sprintf("`-W=no-%s` turns off this warning", kind.name))
+		}
+	}
+	return warnings
+}
+
+// a warning turned off with -W isn't reported even if it's in -Werror
+func (l *Linter) warn(warning Diagnostic) {
+	if isStdFile(warning.span.start.File) || !l.config.enabled[warning.code] {
+		return
+	}
+
+	warning.severity = severityWarning
+	if l.config.asErrors[warning.code] {
+		warning.severity = severityError
+	}
+	l.warnings.Add(warning)
+}
+
+// the code after a statement that control doesn't fall through in the
+// same list of statements can never run. it's only reported once per list
+func (l *Linter) lintStmts(stmts []NodeStmt) {
+	var terminator *Token
+	reported := false
+	for _, stmt := range stmts {
+		if terminator != nil && !reported {
+			l.warn(stmtToken(stmt).PositionedError(codeUnreachableCode, "unreachable code").
+				WithLabel(terminator.span(), "any code after this is never run"))
+			reported = true
+		}
+
+		l.lintStmt(stmt)
+
+		if terminator == nil && !stmtFallsThrough(stmt) {
+			tok := stmtToken(stmt)
+			terminator = &tok
+		}
+	}
+}
+
+func (l *Linter) lintStmt(rawStmt NodeStmt) {
+	switch stmt := rawStmt.(type) {
+	case NodeStmtVarDeclare:
+		if stmt.expr.HasValue() {
+			l.lintExpr(stmt.expr.MustGetValue())
+			l.assigned[stmt.variable] = true
+		}
+		l.declared = append(l.declared, stmt.variable)
+
+	case NodeStmtVarAssign:
+		l.lintExpr(stmt.expr)
+		l.assigned[stmt.variable] = true
+
+	case NodeStmtPointerAssign:
+		// the pointer in the variable is read to write through it
+		l.lintExpr(stmt.expr)
+		l.read[stmt.variable] = true
+
+	case NodeScope:
+		l.lintStmts(stmt.stmts)
+
+	case NodeStmtIf:
+		l.lintIf(stmt)
+
+	case NodeStmtWhile:
+		// `while (1)` is how a loop that's left with break is written
+		if value, constant := constantValue(stmt.expr); constant && value == 0 {
+			l.warn(exprToken(stmt.expr).PositionedError(codeConstantCondition, "while condition is always false").
+				WithNote("the loop's body is never run"))
+		}
+		l.lintExpr(stmt.expr)
+		l.lintStmts(stmt.scope.stmts)
+
+	case NodeStmtBreak:
+	case NodeStmtContinue:
+	case NodeStmtImport:
+
+	case NodeStmtFunctionDefinition:
+		l.currentFunction = stmt.function
+		l.lintStmts(stmt.body.stmts)
+		l.currentFunction = nil
+
+	case NodeFunctionCall:
+		l.lintFuncCall(stmt)
+
+	case NodeIndirectCall:
+		l.lintExpr(stmt)
+
+	case NodeStmtReturn:
+		l.lintExprs(stmt.returns)
+
+	case NodeStmtSyscall:
+		l.lintExprs(stmt.arguments)
+
+	case NodeStmtAsm:
+		for _, v := range stmt.variables {
+			l.read[v] = true
+		}
+
+	default:
+		panic(fmt.Errorf("linter error: don't know how to lint statement: %T", rawStmt))
+	}
+}
+
+func (l *Linter) lintIf(stmt NodeStmtIf) {
+	if value, constant := constantValue(stmt.expr); constant {
+		warning := exprToken(stmt.expr).PositionedError(codeConstantCondition, "if condition is always true")
+		if value == 0 {
+			warning.message = "if condition is always false"
+			warning = warning.WithNote("the if's body is never run")
+		} else if stmt.elseBranch.HasValue() {
+			warning = warning.WithNote("the else branch is never run")
+		}
+		l.warn(warning)
+	}
+	l.lintExpr(stmt.expr)
+	l.lintStmts(stmt.scope.stmts)
+
+	if !stmt.elseBranch.HasValue() {
+		return
+	}
+	switch _else := stmt.elseBranch.MustGetValue().(type) {
+	case NodeElseScope:
+		l.lintStmts(_else.scope.stmts)
+	case NodeElseElif:
+		l.lintIf(_else.ifStmt)
+	default:
+		panic(fmt.Errorf("linter error: don't know how to lint else branch: %T", _else))
+	}
+}
+
+func (l *Linter) lintExprs(exprs []NodeExpr) {
+	for _, e := range exprs {
+		l.lintExpr(e)
+	}
+}
+
+func (l *Linter) lintExpr(rawExpr NodeExpr) {
+	switch expr := rawExpr.(type) {
+	case NodeBinExprAdd:
+		l.lintExprs([]NodeExpr{expr.left, expr.right})
+	case NodeBinExprSubtract:
+		l.lintExprs([]NodeExpr{expr.left, expr.right})
+	case NodeBinExprMultiply:
+		l.lintExprs([]NodeExpr{expr.left, expr.right})
+	case NodeBinExprDivide:
+		l.lintExprs([]NodeExpr{expr.left, expr.right})
+	case NodeBinExprModulo:
+		l.lintExprs([]NodeExpr{expr.left, expr.right})
+
+	case NodeTermIntLiteral:
+
+	case NodeTermIdentifier:
+		l.useSymbol(expr.symbol)
+
+	case NodeFunctionCall:
+		l.lintFuncCall(expr)
+
+	case NodeIndirectCall:
+		l.lintExpr(expr.callee)
+		l.lintExprs(expr.params)
+
+	case NodeTermRoundBracketExpr:
+		l.lintExpr(expr.expr)
+
+	case NodeTermSyscall:
+		l.lintExprs(expr.arguments)
+
+	case NodeTermPointer:
+		l.useSymbol(expr.symbol)
+
+	case NodeTermPointerDereference:
+		l.read[expr.variable] = true
+
+	default:
+		panic(fmt.Errorf("linter error: don't know how to lint expression: %T", rawExpr))
+	}
+}
+
+func (l *Linter) lintFuncCall(call NodeFunctionCall) {
+	l.useSymbol(call.symbol)
+
+	// the variadic builtins name the variadic parameter
+	// which isn't a variable so doesn't have a symbol
+	params := call.params
+	if builtin, ok := call.symbol.(Builtin); ok && (builtin.name == "vaCount" || builtin.name == "vaArg") {
+		params = params[1:]
+	}
+	l.lintExprs(params)
+}
+
+func (l *Linter) useSymbol(symbol Symbol) {
+	switch s := symbol.(type) {
+	case *Variable:
+		l.read[s] = true
+	case *Function:
+		// recursion doesn't count as using a function
+		if s != l.currentFunction {
+			l.used[s] = true
+		}
+	}
+}
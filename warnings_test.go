@@ -0,0 +1,55 @@
+package main
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestWarningFlags(t *testing.T) {
+	// declares a variable it never reads
+	source := "var x = 1;\n"
+
+	tests := []struct {
+		name     string
+		enabled  string
+		asErrors string
+		want     []Severity
+	}{
+		{"default", "all", "", []Severity{severityWarning}},
+		{"turned off", "no-unused-variable", "", []Severity{}},
+		{"only others", "unreachable-code", "", []Severity{}},
+		{"as error", "all", "unused-variable", []Severity{severityError}},
+		{"off wins over error", "no-unused-variable", "unused-variable", []Severity{}},
+		{"error list turning off", "all", "all,no-unused-variable", []Severity{severityWarning}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			config, err := ParseWarningConfig(test.enabled, test.asErrors)
+			if err != nil {
+				t.Fatal(err)
+			}
+			prog, err := resolveSource(t, source)
+			if err != nil {
+				t.Fatal(err)
+			}
+			linter := NewLinter(prog, config)
+
+			got := []Severity{}
+			for _, warning := range linter.Lint() {
+				got = append(got, warning.(Diagnostic).severity)
+			}
+			if !slices.Equal(got, test.want) {
+				t.Errorf("got %v, want %v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestUnknownWarning(t *testing.T) {
+	if _, err := ParseWarningConfig("unused-varaible", ""); err == nil {
+		t.Error("expected an error for a misspelt warning")
+	}
+	if _, err := ParseWarningConfig("all", "no-bogus"); err == nil {
+		t.Error("expected an error for an unknown warning in -Werror")
+	}
+}